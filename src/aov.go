@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AOV is an arbitrary output variable: a feature of the first surface each
+// camera ray hits, rendered alongside the beauty image.
+type AOV int
+
+const (
+	AOVAlbedo AOV = iota
+	AOVNormal
+	AOVDepth
+	AOVUV
+	AOVObjectID
+	AOVMaterialID
+)
+
+var AOVNames = []string{"albedo", "normal", "depth", "uv", "object", "material"}
+
+func (a AOV) String() string {
+	return AOVNames[a]
+}
+
+// Channels names the EXR channels of a layer, with the layer as prefix.
+func (a AOV) Channels() []string {
+	suffixes := map[AOV][]string{
+		AOVAlbedo:     {"R", "G", "B"},
+		AOVNormal:     {"X", "Y", "Z"},
+		AOVDepth:      {"Z"},
+		AOVUV:         {"U", "V"},
+		AOVObjectID:   {"id"},
+		AOVMaterialID: {"id"},
+	}[a]
+	channels := make([]string, len(suffixes))
+	for i, s := range suffixes {
+		channels[i] = a.String() + "." + s
+	}
+	return channels
+}
+
+// ParseAOVs reads a comma-separated list of AOV names, or "all".
+func ParseAOVs(list string) ([]AOV, error) {
+	if list == "all" {
+		aovs := make([]AOV, len(AOVNames))
+		for i := range aovs {
+			aovs[i] = AOV(i)
+		}
+		return aovs, nil
+	}
+	var aovs []AOV
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for i, known := range AOVNames {
+			if name == known {
+				aovs = append(aovs, AOV(i))
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown AOV %q (want all or some of %s)", name, strings.Join(AOVNames, ", "))
+		}
+	}
+	return aovs, nil
+}
+
+// AOVBuffers holds the feature buffers of a render. Albedo and normal are
+// averaged over every sample, which antialiases them like the beauty pass.
+// Depth, UV and IDs can't be meaningfully averaged, so they come from the
+// first sample each pixel takes.
+type AOVBuffers struct {
+	Width, Height        int
+	Albedo, Normal       []Vec3 // sums over Samples
+	Samples              []int
+	Depth                []float64 // distance along the view axis; 0 where the ray missed
+	UV                   [][2]float64
+	ObjectID, MaterialID []int32 // numbered from 1; 0 where the ray missed
+}
+
+func NewAOVBuffers(w, h int) *AOVBuffers {
+	n := w * h
+	return &AOVBuffers{
+		Width: w, Height: h,
+		Albedo: make([]Vec3, n), Normal: make([]Vec3, n), Samples: make([]int, n),
+		Depth: make([]float64, n), UV: make([][2]float64, n),
+		ObjectID: make([]int32, n), MaterialID: make([]int32, n),
+	}
+}
+
+// Framebuffer returns one AOV as colors. With display set the values are
+// remapped to be viewed in an 8-bit image: normals from [-1, 1] to [0, 1],
+// depth to 1 at the camera fading to 0 at the farthest hit, and IDs to
+// distinct colors.
+func (a *AOVBuffers) Framebuffer(aov AOV, display bool) *Framebuffer {
+	fb := NewFramebuffer(a.Width, a.Height)
+	farthest := 0.0
+	for _, d := range a.Depth {
+		farthest = max(farthest, d)
+	}
+	for i := range fb.Pixels {
+		var c Vec3
+		switch aov {
+		case AOVAlbedo:
+			if a.Samples[i] > 0 {
+				c = a.Albedo[i].Scale(1 / float64(a.Samples[i]))
+			}
+		case AOVNormal:
+			if n := a.Normal[i]; !n.NearZero() {
+				c = n.GetUnitVec()
+			}
+			if display {
+				c = c.Add(NewVec3(1, 1, 1)).Scale(0.5)
+			}
+		case AOVDepth:
+			d := a.Depth[i]
+			if display && d > 0 {
+				d = 1 - d/farthest
+			}
+			c = NewVec3(d, d, d)
+		case AOVUV:
+			c = NewVec3(a.UV[i][0], a.UV[i][1], 0)
+		case AOVObjectID, AOVMaterialID:
+			id := a.ObjectID[i]
+			if aov == AOVMaterialID {
+				id = a.MaterialID[i]
+			}
+			c = NewVec3(float64(id), float64(id), float64(id))
+			if display {
+				c = idColor(id)
+			}
+		}
+		fb.Pixels[i] = c
+	}
+	return fb
+}
+
+// idColor scatters IDs over bright, easily told apart colors; 0 stays black.
+func idColor(id int32) Vec3 {
+	if id == 0 {
+		return Vec3{}
+	}
+	h := SplitMix64(uint64(id))
+	channel := func(shift uint) float64 { return 0.2 + 0.8*float64((h>>shift)&0xff)/255 }
+	return NewVec3(channel(0), channel(8), channel(16))
+}
+
+// sceneIDs numbers the objects and materials of a world in the order a walk
+// finds them, so IDs don't change between renders of the same scene.
+type sceneIDs struct {
+	objects   map[any]int32
+	materials map[*Material]int32
+}
+
+func collectSceneIDs(world *HittableList) *sceneIDs {
+	ids := &sceneIDs{objects: map[any]int32{}, materials: map[*Material]int32{}}
+	add := func(object any, m *Material) {
+		if _, ok := ids.objects[object]; !ok {
+			ids.objects[object] = int32(len(ids.objects) + 1)
+		}
+		if _, ok := ids.materials[m]; m != nil && !ok {
+			ids.materials[m] = int32(len(ids.materials) + 1)
+		}
+	}
+	var walk func(h Hittable)
+	walk = func(h Hittable) {
+		switch obj := h.(type) {
+		case *HittableList:
+			for _, child := range obj.Objects {
+				walk(*child)
+			}
+		case *BVHNode:
+			walk(*obj.Left)
+			walk(*obj.Right)
+		case *BVHLeaf:
+			for _, child := range obj.Objects {
+				walk(*child)
+			}
+		case *LinearBVH:
+			for _, child := range obj.Primitives {
+				walk(child)
+			}
+		case *Transform:
+			walk(*obj.Object)
+		case *Sphere:
+			add(obj, obj.Mat)
+		case *Quad:
+			add(obj, obj.Mat)
+		case *Triangle:
+			add(obj, obj.Mat)
+		case *MeshTriangle:
+			add(obj.Mesh, obj.Mesh.Mat)
+		case *ConstantMedium:
+			add(obj, obj.PhaseFunction)
+		}
+	}
+	walk(world)
+	return ids
+}
+
+// recordAOVs adds the first hit of a camera ray to the feature buffers; rec
+// has no material if the ray missed everything.
+func (c *Camera) recordAOVs(r Ray, pixel int, rec *HitRecord, aov *AOVBuffers) {
+	aov.Samples[pixel]++
+	if rec.MaterialPointer == nil {
+		background := c.EnvironmentRadiance(r.Direction)
+		aov.Albedo[pixel].PlusEq(NewVec3(min(background.X, 1), min(background.Y, 1), min(background.Z, 1)))
+		return
+	}
+	aov.Albedo[pixel].PlusEq((*rec.MaterialPointer).AlbedoAt(rec))
+	aov.Normal[pixel].PlusEq(rec.Normal)
+	if aov.Samples[pixel] != 1 {
+		return
+	}
+	forward := c.W.Negate()
+	aov.Depth[pixel] = rec.T * Dot(&r.Direction, &forward)
+	aov.UV[pixel] = [2]float64{rec.U, rec.V}
+	aov.ObjectID[pixel] = c.ids.objects[rec.Object]
+	aov.MaterialID[pixel] = c.ids.materials[rec.MaterialPointer]
+}
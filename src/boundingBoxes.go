@@ -72,6 +72,16 @@ func (aabb *AABB) Hit(r Ray, i *Interval) bool {
 	}
 	return true
 }
+func (aabb *AABB) Centroid() Vec3 {
+	return NewVec3((aabb.X.Min+aabb.X.Max)/2, (aabb.Y.Min+aabb.Y.Max)/2, (aabb.Z.Min+aabb.Z.Max)/2)
+}
+func (aabb *AABB) SurfaceArea() float64 {
+	dx, dy, dz := aabb.X.Size(), aabb.Y.Size(), aabb.Z.Size()
+	if dx < 0 || dy < 0 || dz < 0 {
+		return 0
+	}
+	return 2 * (dx*dy + dy*dz + dz*dx)
+}
 func (aabb *AABB) LongestAxis() int {
 	return ArgMax(aabb.X.Size(), aabb.Y.Size(), aabb.Z.Size())
 }
@@ -1,9 +1,56 @@
 package main
 
 import (
+	"fmt"
+	"math"
+	"math/rand/v2"
 	"sort"
+	"strings"
 )
 
+type BVHSplitMethod int
+
+const (
+	SplitMedian BVHSplitMethod = iota // sort along the longest axis and halve
+	SplitSAH                          // binned surface area heuristic
+)
+
+var BVHSplitMethods = map[string]BVHSplitMethod{"median": SplitMedian, "sah": SplitSAH}
+
+func ParseBVHSplitMethod(name string) (BVHSplitMethod, error) {
+	m, ok := BVHSplitMethods[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown BVH split method %q (want median or sah)", name)
+	}
+	return m, nil
+}
+
+type BVHOptions struct {
+	Method   BVHSplitMethod
+	LeafSize int  // most primitives an SAH leaf may hold
+	Bins     int  // candidate split planes per axis for SAH
+	Flat     bool // flatten the tree into a LinearBVH
+}
+
+// DefaultBVHOptions are the settings the command line starts from.
+var DefaultBVHOptions = BVHOptions{Method: SplitMedian, LeafSize: 4, Bins: 12}
+
+func NewBVH(objects []*Hittable, opts BVHOptions) *Hittable {
+	var root *Hittable
+	if opts.Method == SplitSAH {
+		subList := make([]*Hittable, len(objects))
+		copy(subList, objects)
+		root = newSAHNode(subList, opts)
+	} else {
+		root = NewBVHNode(objects, 0, len(objects))
+	}
+	if opts.Flat {
+		h := Hittable(FlattenBVH(root))
+		return &h
+	}
+	return root
+}
+
 type BVHNode struct {
 	Left, Right *Hittable
 	BBOXField   *AABB
@@ -43,24 +90,159 @@ func NewBVHNode(objects []*Hittable, start, end int) *Hittable {
 	})
 	return &h
 }
-func NewBVHNodeFromList(list *HittableList) *Hittable {
-	return NewBVHNode(list.Objects, 0, len(list.Objects))
+
+func NewBVHNodeFromList(list *HittableList, opts BVHOptions) *Hittable {
+	return NewBVH(list.Objects, opts)
 }
-func (n *BVHNode) Hit(r Ray, i *Interval, rec *HitRecord) bool {
+func (n *BVHNode) Hit(r Ray, i *Interval, rec *HitRecord, rng *rand.Rand) bool {
 	rayInterval := *i
 	if !n.BBOXField.Hit(r, &rayInterval) {
 		return false
 	}
-	hitLeft := (*n.Left).Hit(r, i, rec)
+	hitLeft := (*n.Left).Hit(r, i, rec, rng)
 	var hitT float64
 	if hitLeft {
 		hitT = rec.T
 	} else {
 		hitT = i.Max
 	}
-	hitRight := (*n.Right).Hit(r, NewInterval(i.Min, hitT), rec)
+	hitRight := (*n.Right).Hit(r, NewInterval(i.Min, hitT), rec, rng)
 	return hitLeft || hitRight
 }
 func (n *BVHNode) BBOX() *AABB {
 	return n.BBOXField
 }
+
+// BVHLeaf holds the few primitives an SAH split decided not to separate.
+type BVHLeaf struct {
+	Objects   []*Hittable
+	BBOXField *AABB
+}
+
+func (l *BVHLeaf) Hit(r Ray, i *Interval, rec *HitRecord, rng *rand.Rand) bool {
+	rayInterval := *i
+	if !l.BBOXField.Hit(r, &rayInterval) {
+		return false
+	}
+	hitAnything := false
+	closestSoFar := i.Max
+	for _, obj := range l.Objects {
+		if (*obj).Hit(r, NewInterval(i.Min, closestSoFar), rec, rng) {
+			hitAnything = true
+			closestSoFar = rec.T
+		}
+	}
+	return hitAnything
+}
+func (l *BVHLeaf) BBOX() *AABB {
+	return l.BBOXField
+}
+
+type sahBin struct {
+	bbox  *AABB
+	count int
+}
+
+// newSAHNode partitions objects in place. Each axis is cut into opts.Bins
+// buckets by centroid and the boundary with the lowest expected cost,
+// traversal plus (area * count) of both sides relative to the parent, wins.
+func newSAHNode(objects []*Hittable, opts BVHOptions) *Hittable {
+	bbox := NewEmptyAABB()
+	centroids := NewEmptyAABB()
+	for _, obj := range objects {
+		b := (*obj).BBOX()
+		bbox.MergeAABB(b)
+		c := b.Centroid()
+		centroids.MergeAABB(NewAABBFromPoints(c, c))
+	}
+	leafSize, bins := max(opts.LeafSize, 1), max(opts.Bins, 2)
+	makeLeaf := func() *Hittable {
+		h := Hittable(&BVHLeaf{Objects: objects, BBOXField: bbox})
+		return &h
+	}
+	if len(objects) <= 1 {
+		return makeLeaf()
+	}
+
+	const traversalCost = 1.0 // relative to one primitive intersection
+	bestCost, bestAxis, bestSplit := math.Inf(1), -1, 0
+	parentArea := bbox.SurfaceArea()
+	for axis := range 3 {
+		extent := centroids.AxisInterval(axis)
+		if extent.Size() <= 0 {
+			continue
+		}
+		buckets := make([]sahBin, bins)
+		for k := range buckets {
+			buckets[k].bbox = NewEmptyAABB()
+		}
+		for _, obj := range objects {
+			k := sahBucket((*obj).BBOX(), axis, extent, bins)
+			buckets[k].count++
+			buckets[k].bbox.MergeAABB((*obj).BBOX())
+		}
+
+		// sweep from the right to get suffix areas, then from the left
+		rightArea := make([]float64, bins)
+		rightCount := make([]int, bins)
+		acc, count := NewEmptyAABB(), 0
+		for k := bins - 1; k > 0; k-- {
+			acc.MergeAABB(buckets[k].bbox)
+			count += buckets[k].count
+			rightArea[k], rightCount[k] = acc.SurfaceArea(), count
+		}
+		acc, count = NewEmptyAABB(), 0
+		for k := 0; k < bins-1; k++ {
+			acc.MergeAABB(buckets[k].bbox)
+			count += buckets[k].count
+			if count == 0 || rightCount[k+1] == 0 {
+				continue
+			}
+			cost := traversalCost + (acc.SurfaceArea()*float64(count)+rightArea[k+1]*float64(rightCount[k+1]))/parentArea
+			if cost < bestCost {
+				bestCost, bestAxis, bestSplit = cost, axis, k
+			}
+		}
+	}
+
+	leafCost := float64(len(objects))
+	if len(objects) <= leafSize && (bestAxis < 0 || leafCost <= bestCost) {
+		return makeLeaf()
+	}
+
+	var mid int
+	if bestAxis < 0 {
+		// every centroid coincides, so no plane separates them; split by count
+		mid = len(objects) / 2
+	} else {
+		extent := centroids.AxisInterval(bestAxis)
+		mid = partition(objects, func(h *Hittable) bool {
+			return sahBucket((*h).BBOX(), bestAxis, extent, bins) <= bestSplit
+		})
+	}
+
+	h := Hittable(&BVHNode{
+		Left:      newSAHNode(objects[:mid], opts),
+		Right:     newSAHNode(objects[mid:], opts),
+		BBOXField: bbox,
+	})
+	return &h
+}
+
+func sahBucket(b *AABB, axis int, extent *Interval, bins int) int {
+	c := b.Centroid()
+	k := int(float64(bins) * (c.GetDim(axis) - extent.Min) / extent.Size())
+	return min(max(k, 0), bins-1)
+}
+
+// partition moves the objects matching inLeft to the front and returns how many there are.
+func partition(objects []*Hittable, inLeft func(*Hittable) bool) int {
+	mid := 0
+	for i, obj := range objects {
+		if inLeft(obj) {
+			objects[i], objects[mid] = objects[mid], objects[i]
+			mid++
+		}
+	}
+	return mid
+}
@@ -0,0 +1,110 @@
+package main
+
+import "fmt"
+
+type BVHStats struct {
+	Nodes      int // interior nodes
+	Leaves     int
+	Primitives int
+	Depth      int // longest root-to-leaf path, in nodes
+}
+
+func (s BVHStats) AverageLeafSize() float64 {
+	if s.Leaves == 0 {
+		return 0
+	}
+	return float64(s.Primitives) / float64(s.Leaves)
+}
+
+func (s BVHStats) String() string {
+	return fmt.Sprintf("%d nodes, %d leaves, %d primitives, depth %d, %.2f primitives per leaf",
+		s.Nodes, s.Leaves, s.Primitives, s.Depth, s.AverageLeafSize())
+}
+
+// ComputeBVHStats walks a tree from NewBVH. A median-split node whose children
+// are both primitives counts as one leaf holding them.
+func ComputeBVHStats(root *Hittable) BVHStats {
+	if flat, ok := (*root).(*LinearBVH); ok {
+		return flat.Stats()
+	}
+	var s BVHStats
+	var walk func(h Hittable, depth int)
+	walk = func(h Hittable, depth int) {
+		s.Depth = max(s.Depth, depth)
+		switch n := h.(type) {
+		case *BVHLeaf:
+			s.Leaves++
+			s.Primitives += len(n.Objects)
+		case *BVHNode:
+			if !isBVH(*n.Left) && !isBVH(*n.Right) {
+				s.Leaves++
+				s.Primitives++
+				if *n.Left != *n.Right {
+					s.Primitives++
+				}
+				return
+			}
+			s.Nodes++
+			walk(*n.Left, depth+1)
+			walk(*n.Right, depth+1)
+		default:
+			// a primitive hanging directly off an interior node
+			s.Leaves++
+			s.Primitives++
+		}
+	}
+	walk(*root, 1)
+	return s
+}
+
+func (b *LinearBVH) Stats() BVHStats {
+	var s BVHStats
+	var walk func(index int32, depth int)
+	walk = func(index int32, depth int) {
+		s.Depth = max(s.Depth, depth)
+		node := &b.Nodes[index]
+		if node.Count > 0 {
+			s.Leaves++
+			s.Primitives += int(node.Count)
+			return
+		}
+		s.Nodes++
+		walk(index+1, depth+1)
+		walk(node.Offset, depth+1)
+	}
+	walk(0, 1)
+	return s
+}
+
+func isBVH(h Hittable) bool {
+	switch h.(type) {
+	case *BVHNode, *BVHLeaf:
+		return true
+	}
+	return false
+}
+
+// FindBVHs returns the outermost BVHs in a world, looking through lists,
+// transforms and media, with a short description of where each was found.
+func FindBVHs(world *HittableList) (roots []*Hittable, paths []string) {
+	var walk func(h *Hittable, path string)
+	walk = func(h *Hittable, path string) {
+		switch obj := (*h).(type) {
+		case *BVHNode, *BVHLeaf, *LinearBVH:
+			roots = append(roots, h)
+			paths = append(paths, path)
+		case *HittableList:
+			for i, child := range obj.Objects {
+				walk(child, fmt.Sprintf("%s[%d]", path, i))
+			}
+		case *Transform:
+			walk(obj.Object, path+".transform")
+		case *ConstantMedium:
+			walk(obj.Boundary, path+".boundary")
+		}
+	}
+	for i, obj := range world.Objects {
+		walk(obj, fmt.Sprintf("world[%d]", i))
+	}
+	return roots, paths
+}
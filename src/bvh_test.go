@@ -0,0 +1,124 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+// bvhBenchScenes are the built-in scenes the BVH layouts are compared on:
+// hundreds of small spheres, and boxes next to large media and meshes.
+var bvhBenchScenes = []string{"bouncing", "final"}
+
+// bvhLayouts are every way NewBVH can build a world.
+var bvhLayouts = []struct {
+	name string
+	opts func() BVHOptions
+}{
+	{"median", func() BVHOptions { return DefaultBVHOptions }},
+	{"median-flat", func() BVHOptions { o := DefaultBVHOptions; o.Flat = true; return o }},
+	{"sah", func() BVHOptions { o := DefaultBVHOptions; o.Method = SplitSAH; return o }},
+	{"sah-flat", func() BVHOptions { o := DefaultBVHOptions; o.Method = SplitSAH; o.Flat = true; return o }},
+}
+
+// bvhBenchRays follows each camera ray with one diffuse bounce from its hit,
+// so half the rays are incoherent.
+func bvhBenchRays(cam *Camera, world *HittableList, count int, seed uint64) []Ray {
+	rng := NewRNG(seed, BenchRayStream)
+	rays := make([]Ray, 0, count)
+	var rec HitRecord
+	for len(rays) < count {
+		i, j := rng.IntN(cam.ImageWidth), rng.IntN(cam.ImageHeight)
+		r := cam.GetRay(float64(i), float64(j), rng)
+		rays = append(rays, r)
+		if len(rays) < count && world.Hit(r, NewInterval(0.001, math.Inf(1)), &rec, rng) {
+			direction := rec.Normal.Add(RandomUnitVector(rng))
+			rays = append(rays, NewRay(rec.P, direction, r.Time))
+		}
+	}
+	return rays
+}
+
+// BenchmarkBVH times closest-hit queries through each layout of the same
+// worlds.
+func BenchmarkBVH(b *testing.B) {
+	const seed = 1
+	for _, name := range bvhBenchScenes {
+		s := FindBuiltinScene(name)
+		cam := NewCamera()
+		s.Configure(&cam)
+		cam.InitCamera()
+		rays := bvhBenchRays(&cam, s.World(NewRNG(seed, SceneStream), DefaultBVHOptions), 1<<14, seed)
+		for _, layout := range bvhLayouts {
+			world := s.World(NewRNG(seed, SceneStream), layout.opts())
+			b.Run(name+"/"+layout.name, func(b *testing.B) {
+				rng := NewRNG(seed, BenchHitStream)
+				var rec HitRecord
+				for i := 0; b.Loop(); i++ {
+					world.Hit(rays[i%len(rays)], NewInterval(0.001, math.Inf(1)), &rec, rng)
+				}
+			})
+		}
+	}
+}
+
+// bvhTestPrimitives scatters spheres, moving spheres, boxes and a triangle
+// soup, each with its own material so a hit tells which one it was.
+func bvhTestPrimitives(seed uint64) []*Hittable {
+	rng := NewRNG(seed, SceneStream)
+	point := func() Vec3 { return NewBoundedRandomVec(rng, -10, 10) }
+	var objects []*Hittable
+	for range 100 {
+		objects = append(objects, NewSphere(point(), RandFloatInRange(rng, 0.1, 1), NewLambertian(Vec3{})))
+	}
+	for range 20 {
+		center := point()
+		objects = append(objects, NewMovingSphere(center, center.Add(NewBoundedRandomVec(rng, -1, 1)), 0.5, NewLambertian(Vec3{})))
+	}
+	for range 20 {
+		corner := point()
+		objects = append(objects, NewBox(corner, corner.Add(NewBoundedRandomVec(rng, 0.1, 2)), NewLambertian(Vec3{})))
+	}
+	var vertices []Vec3
+	var faces []MeshFace
+	for i := range 200 {
+		corner := point()
+		vertices = append(vertices, corner, corner.Add(NewBoundedRandomVec(rng, -1, 1)), corner.Add(NewBoundedRandomVec(rng, -1, 1)))
+		faces = append(faces, MeshFace{V: [3]int{3 * i, 3*i + 1, 3*i + 2}, VT: [3]int{-1, -1, -1}, VN: [3]int{-1, -1, -1}})
+	}
+	mesh := NewTriangleMesh(vertices, nil, nil, faces, NewLambertian(Vec3{}))
+	mesh.ComputeVertexNormals()
+	return append(objects, mesh.Triangles()...)
+}
+
+// TestBVHLayoutsAgree checks every layout finds the same closest hit as
+// testing each primitive in turn.
+func TestBVHLayoutsAgree(t *testing.T) {
+	const seed = 1
+	objects := bvhTestPrimitives(seed)
+	reference := NewHittableList(objects...)
+	rng := NewRNG(seed, BenchRayStream)
+	rays := make([]Ray, 5000)
+	for i := range rays {
+		origin := NewBoundedRandomVec(rng, -12, 12)
+		rays[i] = NewRay(origin, NewBoundedRandomVec(rng, -10, 10).Sub(origin), rng.Float64())
+	}
+	for _, layout := range bvhLayouts {
+		world := NewBVH(slices.Clone(objects), layout.opts())
+		hits := 0
+		for i, r := range rays {
+			var want, got HitRecord
+			wantHit := reference.Hit(r, NewInterval(0.001, math.Inf(1)), &want, rng)
+			gotHit := (*world).Hit(r, NewInterval(0.001, math.Inf(1)), &got, rng)
+			if gotHit != wantHit || got.T != want.T || got.MaterialPointer != want.MaterialPointer {
+				t.Fatalf("%s: ray %d hit %v at %v, want %v at %v", layout.name, i, gotHit, got.T, wantHit, want.T)
+			}
+			if gotHit {
+				hits++
+			}
+		}
+		if hits < len(rays)/4 {
+			t.Fatalf("%s: only %d of %d rays hit anything", layout.name, hits, len(rays))
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"math/rand/v2"
+	"runtime"
+	"slices"
+	"sync"
 )
 
 type Camera struct {
@@ -29,6 +33,22 @@ type Camera struct {
 	DefocusDiskU      Vec3
 	DefocusDiskV      Vec3
 	Background        Vec3
+	Environment       Environment // light from rays that leave the scene; nil is the constant Background
+	Workers           int         // 0 uses every available CPU
+	TileSize          int
+	PassSamples       int     // samples per pixel in each progressive pass; 0 renders all in one
+	AdaptiveThreshold float64 // relative error at which a pixel stops sampling; 0 samples every pixel fully
+	MinSamples        int     // samples every pixel takes before it may stop adaptively
+	LightSampling     bool    // sample emitters directly, combined with BSDF sampling by MIS
+	Seed              uint64
+	AOVs              bool // also record first-hit feature buffers into the accumulator
+	lights            *LightList
+	environmentLight  bool // the environment is one of the lights
+	ids               *sceneIDs
+}
+
+type Tile struct {
+	X0, Y0, X1, Y1 int
 }
 
 func NewCamera() Camera {
@@ -43,6 +63,9 @@ func NewCamera() Camera {
 		LookFrom:        NewVec3(0, 0, 0),
 		LookAt:          NewVec3(0, 0, -1),
 		VUP:             NewVec3(0, 1, 0),
+		TileSize:        16,
+		MinSamples:      16,
+		LightSampling:   true,
 	}
 }
 func (c *Camera) InitCamera() {
@@ -70,61 +93,284 @@ func (c *Camera) InitCamera() {
 	c.DefocusDiskU = c.U.Scale(defocusRadius)
 	c.DefocusDiskV = c.V.Scale(defocusRadius)
 }
-func (c *Camera) GetRay(i, j float64) Ray {
-	offset := NewBoundedRandomVec(-0.5, 0.5)
+func (c *Camera) GetRay(i, j float64, rng *rand.Rand) Ray {
+	offset := NewBoundedRandomVec(rng, -0.5, 0.5)
 	pixelSample := c.Pixel00Loc.Add(c.PixelDeltaU.Scale(i + offset.X).Add(c.PixelDeltaV.Scale(j + offset.Y)))
 
 	var rayOrigin Vec3
 	if c.DefocusAngle <= 0 {
 		rayOrigin = c.Center
 	} else {
-		rayOrigin = c.defocusDiskSample()
+		rayOrigin = c.defocusDiskSample(rng)
 	}
 	rayDirection := pixelSample.Sub(rayOrigin)
-	return NewRay(rayOrigin, rayDirection, rand.Float64())
+	return NewRay(rayOrigin, rayDirection, rng.Float64())
 }
-func (c *Camera) RayColor(r Ray, depth int, world Hittable) Vec3 {
+func (c *Camera) RayColor(r Ray, depth int, world Hittable, rng *rand.Rand) Vec3 {
+	return c.rayColor(r, depth, world, 0, rng, nil)
+}
+
+// rayColor traces r, which the previous bounce sampled with density bsdfPDF.
+// A zero bsdfPDF (camera rays, specular bounces) counts emission in full since
+// light sampling couldn't have produced that path.
+func (c *Camera) rayColor(r Ray, depth int, world Hittable, bsdfPDF float64, rng *rand.Rand, first *HitRecord) Vec3 {
 	if depth <= 0 {
 		return NewVec3(0.0, 0.0, 0.0)
 	}
 
 	var rec HitRecord
-	if !world.Hit(r, NewInterval(0.001, math.Inf(1)), &rec) {
-		return c.Background
+	if !world.Hit(r, NewInterval(0.001, math.Inf(1)), &rec, rng) {
+		radiance := c.EnvironmentRadiance(r.Direction)
+		if bsdfPDF > 0 && c.environmentLight {
+			radiance = radiance.Scale(PowerHeuristic(bsdfPDF, c.lights.PDFValue(r.Origin, r.Direction)))
+		}
+		return radiance
+	}
+	if first != nil {
+		*first = rec
 	}
 
 	var scattered Ray
 	var attenuation Vec3
-	colorFromEmission := (*rec.MaterialPointer).Emitted(rec.U, rec.V, rec.P)
+	mat := *rec.MaterialPointer
+	colorFromEmission := mat.Emitted(rec.U, rec.V, rec.P)
+	if bsdfPDF > 0 && c.lights != nil && !colorFromEmission.NearZero() {
+		lightPDF := c.lights.PDFValue(r.Origin, r.Direction)
+		colorFromEmission = colorFromEmission.Scale(PowerHeuristic(bsdfPDF, lightPDF))
+	}
 
-	if !(*rec.MaterialPointer).Scatter(r, &rec, &attenuation, &scattered) {
+	if !mat.Scatter(r, &rec, &attenuation, &scattered, rng) {
 		return colorFromEmission
 	}
-	colorFromScatter := attenuation.Mul(c.RayColor(scattered, depth-1, world))
+	scatterPDF := mat.ScatteringPDF(r, &rec, scattered)
+	var colorFromScatter Vec3
+	// a rejected microfacet sample carries nothing, but its hit is still
+	// light-sampled below
+	if !attenuation.NearZero() {
+		colorFromScatter = attenuation.Mul(c.rayColor(scattered, depth-1, world, scatterPDF, rng, nil))
+	}
 
+	// the scattered ray can't collect emission past the last bounce, so
+	// neither may its light-sampled half of the MIS pair
+	if scatterPDF > 0 && c.lights != nil && depth > 1 {
+		colorFromEmission.PlusEq(c.SampleLights(r, &rec, world, rng))
+	}
 	return colorFromEmission.Add(colorFromScatter)
 }
-func (c *Camera) Render(world *HittableList) {
+
+// SampleLights is the light-sampling half of the MIS estimator: it picks a
+// direction towards an emitter and weighs what it reaches against the chance
+// of the BSDF sampling the same direction.
+func (c *Camera) SampleLights(rIn Ray, rec *HitRecord, world Hittable, rng *rand.Rand) Vec3 {
+	mat := *rec.MaterialPointer
+	shadowRay := NewRay(rec.P, c.lights.Random(rec.P, rng), rIn.Time)
+	lightPDF := c.lights.PDFValue(shadowRay.Origin, shadowRay.Direction)
+	if lightPDF <= 0 {
+		return NewVec3(0, 0, 0)
+	}
+	f := mat.Eval(rIn, rec, shadowRay)
+	if f.NearZero() {
+		return NewVec3(0, 0, 0)
+	}
+
+	var lightRec HitRecord
+	var emitted Vec3
+	if world.Hit(shadowRay, NewInterval(0.001, math.Inf(1)), &lightRec, rng) {
+		emitted = (*lightRec.MaterialPointer).Emitted(lightRec.U, lightRec.V, lightRec.P)
+	} else if c.environmentLight {
+		emitted = c.EnvironmentRadiance(shadowRay.Direction)
+	}
+	if emitted.NearZero() {
+		return NewVec3(0, 0, 0)
+	}
+	weight := PowerHeuristic(lightPDF, mat.ScatteringPDF(rIn, rec, shadowRay))
+	return f.Mul(emitted).Scale(weight / lightPDF)
+}
+
+// EnvironmentRadiance is the light reaching a ray that escapes the scene.
+func (c *Camera) EnvironmentRadiance(direction Vec3) Vec3 {
+	if c.Environment == nil {
+		return c.Background
+	}
+	return c.Environment.Radiance(direction)
+}
+func (c *Camera) Render(world *HittableList) *Framebuffer {
+	accumulator, _ := c.RenderProgressive(context.Background(), world, nil, nil)
+	return accumulator.Framebuffer()
+}
+
+// RenderProgressive renders SamplesPerPixel in passes of PassSamples and calls
+// afterPass with the samples so far after each but the last. Adaptive renders
+// end early once every pixel has converged. When ctx is cancelled the
+// pass in flight stops after its current tiles and the samples so far are
+// returned; pixels in the unfinished pass just have fewer samples. A non-nil
+// accumulator, such as one from a checkpoint, is added to instead of starting
+// from nothing.
+func (c *Camera) RenderProgressive(ctx context.Context, world *HittableList, accumulator *Accumulator, afterPass func(accumulator *Accumulator, samples int) error) (*Accumulator, error) {
 	c.InitCamera()
-	InitImage(c.ImageWidth, c.ImageHeight)
-	lastPercent := -1
-	for i := range c.ImageHeight {
-		for j := range c.ImageWidth {
-			pixelColor := NewVec3(0, 0, 0)
-			for sample := 0; sample < c.SamplesPerPixel; sample++ {
-				r := c.GetRay(float64(j), float64(i))
-				pixelColor.PlusEq(c.RayColor(r, c.MaxDepth, world))
+	c.lights, c.environmentLight = nil, false
+	if c.LightSampling {
+		lights := CollectLights(world)
+		if light, ok := c.Environment.(Light); ok {
+			lights.Lights = append(lights.Lights, light)
+			c.environmentLight = true
+		}
+		if len(lights.Lights) > 0 {
+			c.lights = lights
+		}
+	}
+	if accumulator == nil {
+		accumulator = NewAccumulator(c.ImageWidth, c.ImageHeight)
+	}
+	if c.AOVs {
+		c.ids = collectSceneIDs(world)
+		if accumulator.AOVs == nil {
+			accumulator.AOVs = NewAOVBuffers(c.ImageWidth, c.ImageHeight)
+		}
+	}
+	targets := c.passTargets()
+	resumedAt := slices.Min(accumulator.Samples)
+
+	for pass, target := range targets {
+		if target <= resumedAt && pass < len(targets)-1 {
+			continue
+		}
+		c.renderPass(ctx, world, accumulator, target, len(targets) == 1)
+		if ctx.Err() != nil {
+			break
+		}
+		remaining := 0
+		if c.Adaptive() {
+			remaining = accumulator.UpdateConverged(c.AdaptiveThreshold)
+		}
+		if len(targets) > 1 {
+			if c.Adaptive() {
+				fmt.Printf("Pass %d/%d done, up to %d samples per pixel, %d pixels still sampling.\n", pass+1, len(targets), target, remaining)
+			} else {
+				fmt.Printf("Pass %d/%d done, %d samples per pixel.\n", pass+1, len(targets), target)
+			}
+		}
+		last := pass == len(targets)-1 || (c.Adaptive() && remaining == 0)
+		if afterPass != nil && !last {
+			if err := afterPass(accumulator, target); err != nil {
+				return nil, err
+			}
+		}
+		if last {
+			break
+		}
+	}
+	return accumulator, nil
+}
+
+// passTargets lists the sample count every pixel is brought up to by each
+// pass. Adaptive renders give every pixel MinSamples first and then step by
+// PassSamples, or MinSamples again, until SamplesPerPixel.
+func (c *Camera) passTargets() []int {
+	step := c.PassSamples
+	first := step
+	if c.Adaptive() {
+		first = max(c.MinSamples, 1)
+		if step <= 0 {
+			step = first
+		}
+	}
+	if step <= 0 {
+		return []int{c.SamplesPerPixel}
+	}
+	var targets []int
+	for target := min(first, c.SamplesPerPixel); ; target = min(target+step, c.SamplesPerPixel) {
+		targets = append(targets, target)
+		if target == c.SamplesPerPixel {
+			return targets
+		}
+	}
+}
+
+// Adaptive reports whether pixels stop sampling once they have converged.
+func (c *Camera) Adaptive() bool {
+	return c.AdaptiveThreshold > 0
+}
+
+// renderPass brings every unconverged pixel up to target samples.
+func (c *Camera) renderPass(ctx context.Context, world *HittableList, accumulator *Accumulator, target int, showProgress bool) {
+	tiles := c.Tiles()
+	jobs := make(chan Tile)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	tilesDone, lastPercent := 0, -1
+
+	for range c.WorkerCount() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := NewSampleRNG()
+			for t := range jobs {
+				c.RenderTile(t, world, accumulator, target, rng)
+				if !showProgress {
+					continue
+				}
+				mu.Lock()
+				tilesDone++
+				percent := (tilesDone * 100) / len(tiles)
+				if percent%5 == 0 && percent != lastPercent {
+					fmt.Printf("%d percent done.\n", percent)
+					lastPercent = percent
+				}
+				mu.Unlock()
 			}
-			WriteColor(pixelColor.Scale(c.PixelSamplesScale))
+		}()
+	}
+dispatch:
+	for _, t := range tiles {
+		select {
+		case jobs <- t:
+		case <-ctx.Done():
+			break dispatch
 		}
-		percent := (i*100)/c.ImageHeight + 1
-		if percent%5 == 0 && percent != lastPercent {
-			fmt.Printf("%d percent done.\n", percent)
-			lastPercent = percent
+	}
+	close(jobs)
+	wg.Wait()
+}
+func (c *Camera) RenderTile(t Tile, world *HittableList, accumulator *Accumulator, target int, rng *SampleRNG) {
+	for i := t.Y0; i < t.Y1; i++ {
+		for j := t.X0; j < t.X1; j++ {
+			pixel := i*c.ImageWidth + j
+			if accumulator.Converged[pixel] {
+				continue
+			}
+			for sample := accumulator.Samples[pixel]; sample < target; sample++ {
+				rng.Reset(c.Seed, pixel, sample)
+				r := c.GetRay(float64(j), float64(i), rng.Rand)
+				if !c.AOVs {
+					accumulator.Add(j, i, c.RayColor(r, c.MaxDepth, world, rng.Rand))
+					continue
+				}
+				// the feature buffers reuse the beauty path's first hit
+				var first HitRecord
+				accumulator.Add(j, i, c.rayColor(r, c.MaxDepth, world, 0, rng.Rand, &first))
+				c.recordAOVs(r, pixel, &first, accumulator.AOVs)
+			}
 		}
 	}
 }
-func (c *Camera) defocusDiskSample() Vec3 {
-	p := RandomInUnitDisk()
+func (c *Camera) Tiles() []Tile {
+	size := max(c.TileSize, 1)
+	tiles := make([]Tile, 0, ((c.ImageWidth+size-1)/size)*((c.ImageHeight+size-1)/size))
+	for y := 0; y < c.ImageHeight; y += size {
+		for x := 0; x < c.ImageWidth; x += size {
+			tiles = append(tiles, Tile{X0: x, Y0: y, X1: min(x+size, c.ImageWidth), Y1: min(y+size, c.ImageHeight)})
+		}
+	}
+	return tiles
+}
+func (c *Camera) WorkerCount() int {
+	if c.Workers > 0 {
+		return c.Workers
+	}
+	return runtime.NumCPU()
+}
+func (c *Camera) defocusDiskSample(rng *rand.Rand) Vec3 {
+	p := RandomInUnitDisk(rng)
 	return c.Center.Add(c.DefocusDiskU.Scale(p.X).Add(c.DefocusDiskV.Scale(p.Y)))
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+// renderBuiltin renders a small, seeded version of a built-in scene.
+func renderBuiltin(name string, seed uint64, workers int) *Framebuffer {
+	s := FindBuiltinScene(name)
+	cam := NewCamera()
+	s.Configure(&cam)
+	cam.ImageWidth, cam.SamplesPerPixel, cam.MaxDepth = 24, 4, 8
+	cam.TileSize = 5 // tiles that don't divide the image
+	cam.Seed, cam.Workers = seed, workers
+	return cam.Render(s.World(NewRNG(seed, SceneStream), DefaultBVHOptions))
+}
+
+func TestRenderIsIndependentOfThreads(t *testing.T) {
+	// random scene construction, fog and light sampling all draw numbers
+	for _, name := range []string{"bouncing", "cornell", "lit-marble"} {
+		t.Run(name, func(t *testing.T) {
+			single := renderBuiltin(name, 42, 1)
+			for _, workers := range []int{2, 7} {
+				if fb := renderBuiltin(name, 42, workers); !slices.Equal(fb.Pixels, single.Pixels) {
+					t.Errorf("%d threads render differently from one", workers)
+				}
+			}
+			if fb := renderBuiltin(name, 43, 1); slices.Equal(fb.Pixels, single.Pixels) {
+				t.Error("another seed renders the same image")
+			}
+		})
+	}
+}
+
+func TestAOVsAddedToARender(t *testing.T) {
+	s := FindBuiltinScene("cornell")
+	cam := NewCamera()
+	s.Configure(&cam)
+	cam.ImageWidth, cam.SamplesPerPixel, cam.MaxDepth = 16, 2, 4
+	world := s.World(NewRNG(1, SceneStream), DefaultBVHOptions)
+	accumulator, _ := cam.RenderProgressive(context.Background(), world, nil, nil)
+
+	cam.SamplesPerPixel, cam.AOVs = 4, true
+	accumulator, _ = cam.RenderProgressive(context.Background(), world, accumulator, nil)
+	hits := 0
+	for _, depth := range accumulator.AOVs.Depth {
+		if depth > 0 {
+			hits++
+		}
+	}
+	// the camera looks into the box, so most first samples hit a wall
+	if hits < len(accumulator.AOVs.Depth)/2 {
+		t.Errorf("only %d of %d pixels have a depth", hits, len(accumulator.AOVs.Depth))
+	}
+}
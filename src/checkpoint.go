@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"unsafe"
+)
+
+const checkpointVersion = 1
+
+// Checkpoint is the saved state of a render in progress. Every sample draws
+// from its own (seed, pixel, sample) stream, so the seed and per-pixel sample
+// counts are all the random state needed to carry on exactly where it stopped.
+type Checkpoint struct {
+	Version           int
+	SceneHash         uint64
+	CameraHash        uint64
+	AdaptiveThreshold float64 // Converged was decided with this threshold
+	Accumulator       *Accumulator
+}
+
+func NewCheckpoint(cam *Camera, world *HittableList, accumulator *Accumulator) *Checkpoint {
+	return &Checkpoint{
+		Version:           checkpointVersion,
+		SceneHash:         HashWorld(world),
+		CameraHash:        cam.Hash(),
+		AdaptiveThreshold: cam.AdaptiveThreshold,
+		Accumulator:       accumulator,
+	}
+}
+
+func (cp *Checkpoint) Save(path string) error {
+	return WriteFileAtomic(path, func(w io.Writer) error {
+		return gob.NewEncoder(w).Encode(cp)
+	})
+}
+
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var cp Checkpoint
+	if err := gob.NewDecoder(f).Decode(&cp); err != nil {
+		return nil, fmt.Errorf("%s: not a checkpoint: %w", path, err)
+	}
+	if cp.Version != checkpointVersion {
+		return nil, fmt.Errorf("%s: checkpoint version %d, want %d", path, cp.Version, checkpointVersion)
+	}
+	return &cp, nil
+}
+
+// Resume checks that the checkpoint was rendered from the same scene and
+// camera and returns its samples to continue from.
+func (cp *Checkpoint) Resume(cam *Camera, world *HittableList) (*Accumulator, error) {
+	if cp.SceneHash != HashWorld(world) {
+		return nil, errors.New("checkpoint was rendered from a different scene")
+	}
+	cam.InitCamera()
+	if cp.CameraHash != cam.Hash() {
+		return nil, errors.New("checkpoint was rendered with different camera settings")
+	}
+	acc := cp.Accumulator
+	if acc.Width != cam.ImageWidth || acc.Height != cam.ImageHeight || len(acc.Sum) != acc.Width*acc.Height {
+		return nil, errors.New("checkpoint image size doesn't match the camera")
+	}
+	if cam.AOVs && acc.AOVs == nil {
+		// the samples already taken have no depth, UV or IDs to go with them
+		return nil, errors.New("checkpoint was rendered without feature buffers; resume it without -aovs and -denoise")
+	}
+	if cp.AdaptiveThreshold != cam.AdaptiveThreshold {
+		clear(acc.Converged) // re-judged against the new threshold after the next pass
+	}
+	return acc, nil
+}
+
+// Hash covers the camera settings that change what a sample computes.
+// Sample counts, threads and tiling are left out, so a resumed render may ask
+// for more samples.
+func (c *Camera) Hash() uint64 {
+	h := fnv.New64a()
+	binary.Write(h, binary.LittleEndian, struct {
+		ImageWidth, ImageHeight, MaxDepth              int64
+		AspectRatio, VFov, DefocusAngle, FocusDistance float64
+		LookFrom, LookAt, VUP, Background              Vec3
+		LightSampling                                  bool
+		Seed                                           uint64
+		Environment                                    uint64
+	}{
+		int64(c.ImageWidth), int64(c.ImageHeight), int64(c.MaxDepth),
+		c.AspectRatio, c.VFov, c.DefocusAngle, c.FocusDistance,
+		c.LookFrom, c.LookAt, c.VUP, c.Background,
+		c.LightSampling,
+		c.Seed,
+		hashValue(c.Environment),
+	})
+	return h.Sum64()
+}
+
+// HashWorld walks every object, material and texture reachable from world and
+// hashes their types and values. Shared pointers are hashed by first-visit
+// order, so the result doesn't depend on where things were allocated.
+func HashWorld(world *HittableList) uint64 {
+	return hashValue(world)
+}
+
+func hashValue(x any) uint64 {
+	w := &structHasher{h: fnv.New64a(), seen: map[visit]uint64{}}
+	w.value(reflect.ValueOf(x))
+	return w.h.Sum64()
+}
+
+// visit includes the type because a struct and its first field share an address.
+type visit struct {
+	addr uintptr
+	typ  reflect.Type
+}
+
+type structHasher struct {
+	h    hash.Hash64
+	seen map[visit]uint64
+	buf  [8]byte
+}
+
+func (s *structHasher) uint(x uint64) {
+	binary.LittleEndian.PutUint64(s.buf[:], x)
+	s.h.Write(s.buf[:])
+}
+
+func (s *structHasher) value(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Invalid: // a nil interface passed in directly
+		s.uint(0)
+	case reflect.Bool:
+		if v.Bool() {
+			s.uint(1)
+		} else {
+			s.uint(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		s.uint(uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		s.uint(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		s.uint(math.Float64bits(v.Float()))
+	case reflect.String:
+		s.uint(uint64(v.Len()))
+		io.WriteString(s.h, v.String())
+	case reflect.Pointer:
+		if v.IsNil() {
+			s.uint(0)
+			return
+		}
+		key := visit{v.Pointer(), v.Type()}
+		if id, ok := s.seen[key]; ok {
+			s.uint(id)
+			return
+		}
+		s.seen[key] = uint64(len(s.seen) + 1)
+		s.uint(math.MaxUint64) // first visit
+		s.value(v.Elem())
+	case reflect.Interface:
+		if v.IsNil() {
+			s.uint(0)
+			return
+		}
+		io.WriteString(s.h, v.Elem().Type().String())
+		s.value(v.Elem())
+	case reflect.Struct:
+		for i := range v.NumField() {
+			s.value(v.Field(i))
+		}
+	case reflect.Array:
+		for i := range v.Len() {
+			s.value(v.Index(i))
+		}
+	case reflect.Slice:
+		s.uint(uint64(v.Len()))
+		if elem := v.Type().Elem(); (elem.Kind() == reflect.Float64 || elem == reflect.TypeFor[Vec3]()) && v.Len() > 0 {
+			// image pixels; too many to hash one by one
+			s.h.Write(unsafe.Slice((*byte)(v.UnsafePointer()), v.Len()*int(elem.Size())))
+			return
+		}
+		for i := range v.Len() {
+			s.value(v.Index(i))
+		}
+	default:
+		// maps, funcs and channels don't appear in worlds; note the type only
+		io.WriteString(s.h, v.Type().String())
+	}
+}
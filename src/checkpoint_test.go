@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	s := FindBuiltinScene("cornell")
+	world := s.World(NewRNG(1, SceneStream), DefaultBVHOptions)
+	newCamera := func() Camera {
+		cam := NewCamera()
+		s.Configure(&cam)
+		cam.ImageWidth, cam.SamplesPerPixel, cam.MaxDepth = 16, 2, 4
+		return cam
+	}
+	cam := newCamera()
+	accumulator, _ := cam.RenderProgressive(context.Background(), world, nil, nil)
+	path := filepath.Join(t.TempDir(), "render.checkpoint")
+	if err := NewCheckpoint(&cam, world, accumulator).Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name   string
+		change func(cam *Camera)
+		err    string
+	}{
+		{"same camera", func(*Camera) {}, ""},
+		{"more samples", func(cam *Camera) { cam.SamplesPerPixel = 8 }, ""},
+		{"other seed", func(cam *Camera) { cam.Seed++ }, "checkpoint was rendered with different camera settings"},
+		{"feature buffers", func(cam *Camera) { cam.AOVs = true }, "checkpoint was rendered without feature buffers; resume it without -aovs and -denoise"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cp, err := LoadCheckpoint(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cam := newCamera()
+			test.change(&cam)
+			resumed, err := cp.Resume(&cam, world)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("got error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(resumed.Sum, accumulator.Sum) {
+				t.Error("resumed samples differ from the saved ones")
+			}
+		})
+	}
+}
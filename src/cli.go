@@ -0,0 +1,325 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrReported = errors.New("invalid arguments")
+
+// checkpointPassSamples is the pass size when checkpointing without -pass,
+// since checkpoints are taken between passes.
+const checkpointPassSamples = 16
+
+type Options struct {
+	Scene       string
+	SceneFile   string
+	Output      string
+	Format      string
+	Width       int
+	AspectRatio float64
+	Samples     int
+	MaxDepth    int
+	Threads     int
+	NoLights    bool
+	Seed        uint64
+	BVH         BVHOptions
+	BVHStats    bool
+	PassSamples int
+	Snapshot    time.Duration
+	Adaptive    float64
+	MinSamples  int
+	Heatmap     string
+
+	Environment          string
+	EnvironmentRotation  float64
+	EnvironmentIntensity float64
+	Sky                  bool
+	SkyOptions           SkyOptions
+
+	Display DisplayTransform
+	From    string
+	AOVs    []AOV
+
+	Denoise        bool
+	DenoiseOptions DenoiseOptions
+	Raw            string
+
+	Checkpoint      string
+	CheckpointEvery time.Duration
+	Resume          bool
+
+	set map[string]bool // flags given explicitly on the command line
+}
+
+// aspectFlag accepts either a number or a ratio like 16:9.
+type aspectFlag struct{ value *float64 }
+
+func (a aspectFlag) String() string {
+	if a.value == nil || *a.value == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(*a.value, 'g', -1, 64)
+}
+func (a aspectFlag) Set(s string) error {
+	w, h, isRatio := strings.Cut(s, ":")
+	if !isRatio {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return errors.New("want a number or a ratio like 16:9")
+		}
+		*a.value = v
+		return nil
+	}
+	fw, err1 := strconv.ParseFloat(w, 64)
+	fh, err2 := strconv.ParseFloat(h, 64)
+	if err1 != nil || err2 != nil || fh == 0 {
+		return errors.New("want a number or a ratio like 16:9")
+	}
+	*a.value = fw / fh
+	return nil
+}
+
+func ParseArgs(args []string, stderr io.Writer) (*Options, error) {
+	opts := &Options{set: map[string]bool{}, BVH: DefaultBVHOptions, SkyOptions: DefaultSky, Display: DefaultDisplay, DenoiseOptions: DefaultDenoiseOptions}
+	fs := flag.NewFlagSet("raytracer", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	fs.StringVar(&opts.Scene, "scene", "final", "built-in scene to render")
+	fs.StringVar(&opts.SceneFile, "file", "", "JSON scene file to render instead of a built-in scene")
+	fs.StringVar(&opts.Output, "o", "../out.ppm", "output image path")
+	fs.StringVar(&opts.Format, "format", "", "output format ("+strings.Join(ImageFormats, ", ")+"); defaults to the output extension")
+	fs.StringVar(&opts.Environment, "env", "", "light the scene with this equirectangular .hdr, .pfm or .exr image instead of its background")
+	fs.Float64Var(&opts.EnvironmentRotation, "env-rotation", 0, "turn the -env image this many degrees about the vertical axis")
+	fs.Float64Var(&opts.EnvironmentIntensity, "env-intensity", 1, "multiply the -env image's radiance by this")
+	fs.BoolVar(&opts.Sky, "sky", false, "light the scene with a daylight sky and sun instead of its background")
+	fs.Float64Var(&opts.SkyOptions.Elevation, "sun-elevation", DefaultSky.Elevation, "with -sky, the sun's height above the horizon in degrees")
+	fs.Float64Var(&opts.SkyOptions.Azimuth, "sun-azimuth", DefaultSky.Azimuth, "with -sky, the sun's direction in degrees from -Z towards +X")
+	fs.Float64Var(&opts.SkyOptions.Turbidity, "turbidity", DefaultSky.Turbidity, "with -sky, haziness from 2 (clear) to 10 (hazy)")
+	fs.Float64Var(&opts.SkyOptions.SunRadius, "sun-radius", DefaultSky.SunRadius, "with -sky, the sun's angular radius in degrees; larger suns cast softer shadows")
+	fs.Float64Var(&opts.Display.Exposure, "exposure", 0, "exposure adjustment in stops for 8-bit output")
+	fs.Func("tonemap", "tone curve for 8-bit output: "+strings.Join(slices.Sorted(maps.Keys(ToneOperators)), ", ")+" (default clamp)", func(s string) (err error) {
+		opts.Display.Operator, err = ParseToneOperator(s)
+		return err
+	})
+	fs.Float64Var(&opts.Display.WhitePoint, "white", DefaultDisplay.WhitePoint, "radiance that reinhard-extended maps to white")
+	fs.Func("oetf", "display encoding for 8-bit output: srgb, gamma2 or linear (default srgb)", func(s string) (err error) {
+		opts.Display.Encoding, err = ParseTransferFunction(s)
+		return err
+	})
+	fs.Func("aovs", "also output these feature buffers: all or some of "+strings.Join(AOVNames, ",")+"; layers of an .exr output, otherwise files like out.albedo.png", func(s string) (err error) {
+		opts.AOVs, err = ParseAOVs(s)
+		return err
+	})
+	fs.BoolVar(&opts.Denoise, "denoise", false, "filter the render guided by its albedo, normal and depth before saving it")
+	fs.Float64Var(&opts.DenoiseOptions.Strength, "denoise-strength", DefaultDenoiseOptions.Strength, "how much noise the denoiser smooths away, in standard deviations")
+	fs.IntVar(&opts.DenoiseOptions.Iterations, "denoise-iterations", DefaultDenoiseOptions.Iterations, "denoiser passes; each doubles its radius")
+	fs.StringVar(&opts.Raw, "raw", "", "with -denoise, also save the unfiltered render to this path")
+	fs.StringVar(&opts.From, "from", "", "tone map this .pfm, .hdr or .exr image to -o instead of rendering")
+	fs.IntVar(&opts.Width, "width", 0, "image width in pixels")
+	fs.Var(aspectFlag{&opts.AspectRatio}, "aspect", "aspect ratio, e.g. 1.5 or 16:9")
+	fs.IntVar(&opts.Samples, "spp", 0, "samples per pixel")
+	fs.IntVar(&opts.MaxDepth, "depth", 0, "maximum ray bounce depth")
+	fs.IntVar(&opts.PassSamples, "pass", 0, "render progressively in passes of this many samples per pixel, saving the output after each")
+	fs.Float64Var(&opts.Adaptive, "adaptive", 0, "stop sampling a pixel once its 95% confidence interval is within this fraction of its brightness, e.g. 0.05; -spp is then the most a pixel takes")
+	fs.IntVar(&opts.MinSamples, "min-spp", 0, "with -adaptive, samples every pixel takes before it may stop (default 16)")
+	fs.StringVar(&opts.Heatmap, "heatmap", "", "also write an image of the samples each pixel took to this path")
+	fs.StringVar(&opts.Checkpoint, "checkpoint", "", "save the render state to this file between passes (of 16 samples unless -pass is set) and when done; without -pass the output is only written at the end")
+	fs.DurationVar(&opts.CheckpointEvery, "checkpoint-every", 5*time.Minute, "save the -checkpoint at most this often")
+	fs.BoolVar(&opts.Resume, "resume", false, "continue the render saved in -checkpoint up to -spp; the scene and camera must match")
+	fs.DurationVar(&opts.Snapshot, "snapshot-every", 0, "with -pass, save the output at most this often, e.g. 30s (0 saves after every pass)")
+	fs.IntVar(&opts.Threads, "threads", 0, "render threads (0 uses every CPU)")
+	fs.Uint64Var(&opts.Seed, "seed", 0, "random seed; the same seed renders the same image")
+	fs.Func("bvh", "BVH split method: median or sah (default median)", func(s string) (err error) {
+		opts.BVH.Method, err = ParseBVHSplitMethod(s)
+		return err
+	})
+	fs.IntVar(&opts.BVH.LeafSize, "bvh-leaf", DefaultBVHOptions.LeafSize, "most primitives per SAH leaf")
+	fs.IntVar(&opts.BVH.Bins, "bvh-bins", DefaultBVHOptions.Bins, "SAH split candidates per axis")
+	fs.BoolVar(&opts.BVH.Flat, "bvh-flat", false, "flatten BVHs into a contiguous, iteratively traversed array")
+	fs.BoolVar(&opts.BVHStats, "bvh-stats", false, "print depth, node count and leaf size of every BVH")
+	fs.BoolVar(&opts.NoLights, "no-light-sampling", false, "find lights by random bounces only")
+
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: raytracer [flags]\n\nFlags:\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(stderr, "\nBuilt-in scenes:\n")
+		for _, s := range BuiltinScenes {
+			fmt.Fprintf(stderr, "  %-12s %s\n", s.Name, s.Description)
+		}
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		}
+		return nil, ErrReported // the flag package already printed it with the usage
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	fs.Visit(func(f *flag.Flag) { opts.set[f.Name] = true })
+
+	if opts.set["scene"] && opts.set["file"] {
+		return nil, errors.New("-scene and -file cannot be used together")
+	}
+	if !opts.set["file"] && FindBuiltinScene(opts.Scene) == nil {
+		return nil, fmt.Errorf("unknown scene %q (see -help for the list)", opts.Scene)
+	}
+	for _, check := range []struct {
+		name string
+		ok   bool
+	}{
+		{"width", opts.Width > 0},
+		{"aspect", opts.AspectRatio > 0},
+		{"spp", opts.Samples > 0},
+		{"depth", opts.MaxDepth > 0},
+		{"bvh-leaf", opts.BVH.LeafSize > 0},
+		{"bvh-bins", opts.BVH.Bins > 1},
+		{"min-spp", opts.MinSamples > 0},
+	} {
+		if opts.set[check.name] && !check.ok {
+			return nil, fmt.Errorf("-%s must be positive", check.name)
+		}
+	}
+	if opts.Threads < 0 {
+		return nil, errors.New("-threads must not be negative")
+	}
+	if opts.Adaptive < 0 {
+		return nil, errors.New("-adaptive must not be negative")
+	}
+	if opts.Resume && opts.Checkpoint == "" {
+		return nil, errors.New("-resume needs -checkpoint")
+	}
+	if opts.CheckpointEvery < 0 {
+		return nil, errors.New("-checkpoint-every must not be negative")
+	}
+	if (opts.set["env-rotation"] || opts.set["env-intensity"]) && opts.Environment == "" {
+		return nil, errors.New("-env-rotation and -env-intensity need -env")
+	}
+	if opts.Sky && opts.Environment != "" {
+		return nil, errors.New("-sky and -env cannot be used together")
+	}
+	for _, name := range []string{"sun-elevation", "sun-azimuth", "turbidity", "sun-radius"} {
+		if opts.set[name] && !opts.Sky {
+			return nil, fmt.Errorf("-%s needs -sky", name)
+		}
+	}
+	if opts.Sky {
+		if err := opts.SkyOptions.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if opts.EnvironmentIntensity < 0 {
+		return nil, errors.New("-env-intensity must not be negative")
+	}
+	if opts.Raw != "" && !opts.Denoise {
+		return nil, errors.New("-raw needs -denoise")
+	}
+	if opts.Raw != "" {
+		if _, err := ImageWriterForPath(opts.Raw); err != nil {
+			return nil, fmt.Errorf("-raw: %v", err)
+		}
+	}
+	if opts.Heatmap != "" {
+		if _, err := ImageWriterForPath(opts.Heatmap); err != nil {
+			return nil, fmt.Errorf("-heatmap: %v", err)
+		}
+	}
+	if opts.Output == "" {
+		return nil, errors.New("-o must not be empty")
+	}
+	if _, err := opts.ImageWriter(); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// ImageWriter picks the output writer and gives 8-bit ones the display transform.
+func (o *Options) ImageWriter() (ImageWriter, error) {
+	if o.Format != "" {
+		writer, err := ImageWriterForFormat(o.Format)
+		if err != nil {
+			return nil, err
+		}
+		// an unknown extension is fine, but a known one has to agree
+		if byPath, err := ImageWriterForPath(o.Output); err == nil && reflect.TypeOf(byPath) != reflect.TypeOf(writer) {
+			return nil, fmt.Errorf("-format %s doesn't match the extension of %q", o.Format, o.Output)
+		}
+		return WithDisplay(writer, o.Display), nil
+	}
+	writer, err := ImageWriterForPath(o.Output)
+	if err != nil {
+		return nil, fmt.Errorf("%v; pass -format or use one of the extensions %s", err, strings.Join(ImageFormats, ", "))
+	}
+	return WithDisplay(writer, o.Display), nil
+}
+
+// Load builds the camera and world, applying any quality overrides.
+func (o *Options) Load() (Camera, *HittableList, error) {
+	var cam Camera
+	var world *HittableList
+	if o.SceneFile != "" {
+		scene, err := LoadScene(o.SceneFile, o.Seed, o.BVH)
+		if err != nil {
+			return cam, nil, err
+		}
+		cam, world = scene.Camera, scene.World
+	} else {
+		s := FindBuiltinScene(o.Scene)
+		cam = NewCamera()
+		s.Configure(&cam)
+		cam.Seed = o.Seed
+		world = s.World(NewRNG(o.Seed, SceneStream), o.BVH)
+	}
+
+	if o.Environment != "" {
+		env, err := LoadEnvironmentMap(o.Environment, o.EnvironmentRotation, o.EnvironmentIntensity)
+		if err != nil {
+			return cam, nil, err
+		}
+		cam.Environment = env
+	}
+	if o.Sky {
+		cam.Environment = NewPreethamSky(o.SkyOptions)
+	}
+	if o.set["width"] {
+		cam.ImageWidth = o.Width
+	}
+	if o.set["aspect"] {
+		cam.AspectRatio = o.AspectRatio
+	}
+	if o.set["spp"] {
+		cam.SamplesPerPixel = o.Samples
+	}
+	if o.set["depth"] {
+		cam.MaxDepth = o.MaxDepth
+	}
+	cam.Workers = o.Threads
+	cam.PassSamples = o.PassSamples
+	cam.AOVs = len(o.AOVs) > 0 || o.Denoise
+	if o.Checkpoint != "" && cam.PassSamples == 0 {
+		cam.PassSamples = checkpointPassSamples
+	}
+	if o.set["adaptive"] {
+		cam.AdaptiveThreshold = o.Adaptive
+	}
+	if o.set["min-spp"] {
+		if cam.AdaptiveThreshold == 0 {
+			return cam, nil, errors.New("-min-spp needs -adaptive or a scene with an adaptive_threshold")
+		}
+		cam.MinSamples = o.MinSamples
+	}
+	if o.NoLights {
+		cam.LightSampling = false
+	}
+	return cam, world, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+)
+
+// CoatedDiffuse is a diffuse base under a dielectric varnish, like glossy
+// plastic or lacquered wood. The coat reflects by Fresnel off GGX facets; what
+// it lets through bounces between the base and the underside of the coat,
+// where total internal reflection sends much of it back down, before coming
+// out diffusely. Light crossing the coat is tinted by CoatColor for each unit
+// of Thickness it travels through.
+//
+// As in Principled, a smooth coat keeps the smallest roughness GGX can still
+// sample, so both layers share one density for MIS.
+type CoatedDiffuse struct {
+	Tex       *Texture // color of the base
+	Roughness *Texture // of the coat; 0 is a polished finish
+	IOR       float64  // of the coat
+	CoatColor Vec3     // from 0 to 1 per channel
+	Thickness float64  // 0 leaves the coat clear whatever its color
+	fdr       float64  // the share of diffuse light inside the coat it reflects back down
+	NoEmittable
+}
+
+func NewCoatedDiffuse(albedo Vec3, roughness, ior float64) *Material {
+	return NewCoatedDiffuseMaterial(CoatedDiffuse{
+		Tex:       NewSolidColor(albedo),
+		Roughness: scalarTexture(roughness),
+		IOR:       ior,
+		CoatColor: NewVec3(1, 1, 1),
+	})
+}
+
+// NewCoatedDiffuseMaterial fills in the defaults of a grey base under a
+// smooth coat of index 1.5.
+func NewCoatedDiffuseMaterial(c CoatedDiffuse) *Material {
+	if c.Tex == nil {
+		c.Tex = scalarTexture(0.8)
+	}
+	if c.Roughness == nil {
+		c.Roughness = scalarTexture(0)
+	}
+	if c.IOR <= 0 {
+		c.IOR = 1.5
+	}
+	c.fdr = internalDiffuseReflectance(c.IOR)
+	m := Material(&c)
+	return &m
+}
+
+// internalDiffuseReflectance integrates the Fresnel reflectance seen from
+// inside a layer of index eta over a cosine-weighted hemisphere.
+func internalDiffuseReflectance(eta float64) float64 {
+	const steps = 1024
+	var sum float64
+	for i := range steps {
+		cosI := (float64(i) + 0.5) / steps
+		sum += FresnelDielectric(cosI, 1/eta) * 2 * cosI / steps
+	}
+	return sum
+}
+
+func (c *CoatedDiffuse) coat(rec *HitRecord) GGX {
+	r := scalarAt(c.Roughness, rec)
+	return GGX{Alpha: max(r*r, minAlpha)}
+}
+
+// specularChance is how often Scatter samples the coat rather than the base.
+func (c *CoatedDiffuse) specularChance(base Vec3, wo Vec3) float64 {
+	F := FresnelDielectric(wo.Z, c.IOR)
+	total := F + (1-F)*Luminance(base)
+	if total <= 0 {
+		return 1
+	}
+	return F / total
+}
+
+// transmittance is how much of the light survives the coat on the way down
+// along wi and back up along wo.
+func (c *CoatedDiffuse) transmittance(wo, wi Vec3) Vec3 {
+	if c.Thickness == 0 {
+		return NewVec3(1, 1, 1)
+	}
+	refracted := func(cosI float64) float64 {
+		return math.Sqrt(max(0, 1-(1-cosI*cosI)/(c.IOR*c.IOR)))
+	}
+	length := c.Thickness * (1/refracted(wo.Z) + 1/refracted(wi.Z))
+	return NewVec3(math.Pow(c.CoatColor.X, length), math.Pow(c.CoatColor.Y, length), math.Pow(c.CoatColor.Z, length))
+}
+
+// evalAndPDF gives the BSDF times cosine for scattering wo into wi and the
+// density of Scatter picking wi.
+func (c *CoatedDiffuse) evalAndPDF(base Vec3, ggx GGX, wo, wi Vec3) (Vec3, float64) {
+	if wi.Z <= 0 {
+		return Vec3{}, 0
+	}
+	pSpecular := c.specularChance(base, wo)
+	h := wo.Add(wi).GetUnitVec()
+	cosD := Dot(&wo, &h)
+	specular := FresnelDielectric(cosD, c.IOR) * ggx.D(h) * ggx.G2(wo, wi) / (4 * wo.Z)
+	f := NewVec3(specular, specular, specular)
+
+	// the base's interreflections with the coat form a geometric series; the
+	// squared index spreads what gets out over the wider cone outside
+	through := (1 - FresnelDielectric(wo.Z, c.IOR)) * (1 - FresnelDielectric(wi.Z, c.IOR)) / (c.IOR * c.IOR)
+	diffuse := base.Div(NewVec3(1, 1, 1).Sub(base.Scale(c.fdr))).Mul(c.transmittance(wo, wi))
+	f.PlusEq(diffuse.Scale(through * wi.Z / math.Pi))
+
+	pdf := pSpecular*ggx.VisiblePDF(wo, h)/(4*cosD) + (1-pSpecular)*wi.Z/math.Pi
+	return f, pdf
+}
+
+func (c *CoatedDiffuse) Scatter(rIn Ray, rec *HitRecord, attenuation *Vec3, scattered *Ray, rng *rand.Rand) bool {
+	frame, wo := shadingFrame(rIn, rec)
+	if wo.Z <= 0 {
+		return false
+	}
+	base, ggx := c.AlbedoAt(rec), c.coat(rec)
+	var wi Vec3
+	if rng.Float64() < c.specularChance(base, wo) {
+		h := ggx.SampleVisible(wo, rng)
+		wi = h.Scale(2 * Dot(&wo, &h)).Sub(wo)
+	} else {
+		wi = NewVec3(0, 0, 1).Add(RandomUnitVector(rng)).GetUnitVec()
+	}
+	f, pdf := c.evalAndPDF(base, ggx, wo, wi)
+	if pdf <= 0 {
+		return absorb(rIn, rec, frame, wo, attenuation, scattered)
+	}
+	*scattered = NewRay(rec.P, frame.Transform(wi), rIn.Time)
+	*attenuation = f.Scale(1 / pdf)
+	return true
+}
+
+func (c *CoatedDiffuse) ScatteringPDF(rIn Ray, rec *HitRecord, scattered Ray) float64 {
+	frame, wo := shadingFrame(rIn, rec)
+	if wo.Z <= 0 {
+		return 0
+	}
+	_, pdf := c.evalAndPDF(c.AlbedoAt(rec), c.coat(rec), wo, frame.Local(scattered.Direction.GetUnitVec()))
+	return pdf
+}
+
+func (c *CoatedDiffuse) Eval(rIn Ray, rec *HitRecord, scattered Ray) Vec3 {
+	frame, wo := shadingFrame(rIn, rec)
+	if wo.Z <= 0 {
+		return Vec3{}
+	}
+	f, _ := c.evalAndPDF(c.AlbedoAt(rec), c.coat(rec), wo, frame.Local(scattered.Direction.GetUnitVec()))
+	return f
+}
+
+func (c *CoatedDiffuse) AlbedoAt(rec *HitRecord) Vec3 {
+	return (*c.Tex).Value(rec.U, rec.V, rec.P)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// DenoiseOptions tune the à-trous filter. Larger sigmas let it blur across
+// bigger differences in that feature.
+type DenoiseOptions struct {
+	Iterations  int     // each one doubles the filter's reach; 5 covers about 60 pixels
+	Strength    float64 // how many standard deviations of noise count as the same color
+	SigmaNormal float64 // exponent on the cosine between normals; higher keeps more edges
+	SigmaDepth  float64 // relative depth change per pixel of distance treated as one surface
+	Workers     int     // 0 uses every available CPU, like Camera.Workers
+}
+
+var DefaultDenoiseOptions = DenoiseOptions{Iterations: 5, Strength: 4, SigmaNormal: 64, SigmaDepth: 0.05}
+
+// B3 spline weights of the 5x5 à-trous kernel.
+var atrousKernel = [5]float64{1.0 / 16, 1.0 / 4, 3.0 / 8, 1.0 / 4, 1.0 / 16}
+
+// Denoise runs an edge-avoiding à-trous wavelet filter over the averaged
+// samples, in the spirit of SVGF. Lighting is divided by albedo first so
+// textures stay sharp, and each pass blurs less across pixels whose normals,
+// depths or brightness differ, measuring brightness against the noise the
+// accumulator saw in each pixel. It needs the albedo, normal and depth AOVs.
+func Denoise(accumulator *Accumulator, opts DenoiseOptions) (*Framebuffer, error) {
+	aov := accumulator.AOVs
+	if aov == nil {
+		return nil, errors.New("denoising needs the AOV buffers")
+	}
+	w, h := accumulator.Width, accumulator.Height
+	fb := accumulator.Framebuffer()
+	albedo := aov.Framebuffer(AOVAlbedo, false)
+	normals := aov.Framebuffer(AOVNormal, false)
+
+	// demodulate, and estimate the variance of each pixel's mean
+	illumination := make([]Vec3, w*h)
+	variance := make([]float64, w*h)
+	for i, c := range fb.Pixels {
+		a := albedo.Pixels[i]
+		illumination[i] = NewVec3(demodulate(c.X, a.X), demodulate(c.Y, a.Y), demodulate(c.Z, a.Z))
+		if n := accumulator.Samples[i]; n > 1 {
+			la := max(Luminance(a), 0.01)
+			variance[i] = accumulator.LumM2[i] / float64(n-1) / float64(n) / (la * la)
+		}
+	}
+	variance = blurVariance(variance, w, h)
+
+	next := make([]Vec3, w*h)
+	nextVariance := make([]float64, w*h)
+	for iteration := range max(opts.Iterations, 0) {
+		step := 1 << iteration
+		parallelRows(h, opts.Workers, func(y int) {
+			for x := range w {
+				p := y*w + x
+				lp, np, dp := Luminance(illumination[p]), normals.Pixels[p], aov.Depth[p]
+				sigmaL := opts.Strength*math.Sqrt(variance[p]) + 1e-6
+				var sum Vec3
+				var weights, varianceSum float64
+				for ky := range 5 {
+					qy := y + (ky-2)*step
+					if qy < 0 || qy >= h {
+						continue
+					}
+					for kx := range 5 {
+						qx := x + (kx-2)*step
+						if qx < 0 || qx >= w {
+							continue
+						}
+						q := qy*w + qx
+						weight := atrousKernel[kx] * atrousKernel[ky]
+						if q != p {
+							dq := aov.Depth[q]
+							if (dp > 0) != (dq > 0) {
+								continue // surface next to background
+							}
+							if dp > 0 {
+								distance := math.Hypot(float64(qx-x), float64(qy-y))
+								weight *= math.Exp(-math.Abs(dp-dq) / (opts.SigmaDepth * dp * distance))
+								weight *= math.Pow(max(Dot(&np, &normals.Pixels[q]), 0), opts.SigmaNormal)
+							}
+							weight *= math.Exp(-math.Abs(lp-Luminance(illumination[q])) / sigmaL)
+						}
+						sum.PlusEq(illumination[q].Scale(weight))
+						weights += weight
+						varianceSum += weight * weight * variance[q]
+					}
+				}
+				next[p] = sum.Scale(1 / weights)
+				nextVariance[p] = varianceSum / (weights * weights)
+			}
+		})
+		illumination, next = next, illumination
+		variance, nextVariance = nextVariance, variance
+	}
+
+	out := NewFramebuffer(w, h)
+	for i, l := range illumination {
+		a := albedo.Pixels[i]
+		out.Pixels[i] = NewVec3(remodulate(l.X, a.X), remodulate(l.Y, a.Y), remodulate(l.Z, a.Z))
+	}
+	return out, nil
+}
+
+// Dark albedo channels carry the color through unchanged rather than dividing
+// by almost nothing.
+const minDemodulationAlbedo = 0.01
+
+func demodulate(c, albedo float64) float64 {
+	if albedo < minDemodulationAlbedo {
+		return c
+	}
+	return c / albedo
+}
+
+func remodulate(l, albedo float64) float64 {
+	if albedo < minDemodulationAlbedo {
+		return l
+	}
+	return l * albedo
+}
+
+// blurVariance smooths the per-pixel variance with a 3x3 Gaussian, since a
+// few samples give a noisy estimate of it.
+func blurVariance(variance []float64, w, h int) []float64 {
+	kernel := [3]float64{0.25, 0.5, 0.25}
+	out := make([]float64, len(variance))
+	for y := range h {
+		for x := range w {
+			var sum, weights float64
+			for ky := range 3 {
+				for kx := range 3 {
+					qx, qy := x+kx-1, y+ky-1
+					if qx < 0 || qx >= w || qy < 0 || qy >= h {
+						continue
+					}
+					weight := kernel[kx] * kernel[ky]
+					sum += weight * variance[qy*w+qx]
+					weights += weight
+				}
+			}
+			out[y*w+x] = sum / weights
+		}
+	}
+	return out
+}
+
+func parallelRows(h, workers int, row func(y int)) {
+	var wg sync.WaitGroup
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	for k := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for y := k; y < h; y += workers {
+				row(y)
+			}
+		}()
+	}
+	wg.Wait()
+}
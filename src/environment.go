@@ -0,0 +1,167 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"sort"
+)
+
+// Environment is the light arriving from infinitely far away, seen by rays
+// that leave the scene without hitting anything.
+type Environment interface {
+	Radiance(direction Vec3) Vec3
+}
+
+// ConstantEnvironment is the same color in every direction, the way
+// Camera.Background has always lit scenes.
+type ConstantEnvironment struct {
+	Color Vec3
+}
+
+func (e *ConstantEnvironment) Radiance(direction Vec3) Vec3 {
+	return e.Color
+}
+
+// GradientEnvironment blends from Bottom straight down to Top straight up.
+type GradientEnvironment struct {
+	Bottom, Top Vec3
+}
+
+func (e *GradientEnvironment) Radiance(direction Vec3) Vec3 {
+	t := 0.5 * (direction.GetUnitVec().Y + 1)
+	return e.Bottom.Scale(1 - t).Add(e.Top.Scale(t))
+}
+
+// EnvironmentMap wraps an equirectangular image around the scene: +Y is the
+// top row, the image's center column faces +X, and it runs around the way
+// sphere UVs do. It is also a Light, sampled in proportion to the luminance
+// each pixel covers on the sphere.
+type EnvironmentMap struct {
+	Image     *Framebuffer
+	Rotation  float64 // degrees about +Y
+	Intensity float64 // multiplies every pixel
+	rows      piecewiseConstant
+	columns   []piecewiseConstant
+}
+
+func NewEnvironmentMap(image *Framebuffer, rotation, intensity float64) *EnvironmentMap {
+	e := &EnvironmentMap{Image: image, Rotation: rotation, Intensity: intensity}
+	w, h := image.Width, image.Height
+	rowWeights := make([]float64, h)
+	e.columns = make([]piecewiseConstant, h)
+	for y := range h {
+		// rows near the poles cover less of the sphere
+		sinTheta := math.Sin(math.Pi * (float64(y) + 0.5) / float64(h))
+		weights := make([]float64, w)
+		for x := range w {
+			weights[x] = max(Luminance(image.At(x, y)), 0) * sinTheta
+		}
+		e.columns[y] = newPiecewiseConstant(weights)
+		rowWeights[y] = e.columns[y].total
+	}
+	e.rows = newPiecewiseConstant(rowWeights)
+	return e
+}
+
+// LoadEnvironmentMap reads a .hdr, .pfm or .exr image as an environment.
+func LoadEnvironmentMap(path string, rotation, intensity float64) (*EnvironmentMap, error) {
+	image, err := ReadHDRImage(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewEnvironmentMap(image, rotation, intensity), nil
+}
+
+func (e *EnvironmentMap) Radiance(direction Vec3) Vec3 {
+	x, y := e.pixel(direction)
+	return e.Image.At(x, y).Scale(e.Intensity)
+}
+
+// pixel finds the image pixel seen in a direction.
+func (e *EnvironmentMap) pixel(direction Vec3) (x, y int) {
+	u, theta := e.uv(direction)
+	x = min(int(u*float64(e.Image.Width)), e.Image.Width-1)
+	y = min(int(theta/math.Pi*float64(e.Image.Height)), e.Image.Height-1)
+	return x, y
+}
+
+// uv gives the horizontal image coordinate in [0, 1) and the angle down from +Y.
+func (e *EnvironmentMap) uv(direction Vec3) (u, theta float64) {
+	d := direction.GetUnitVec()
+	theta = math.Acos(max(-1, min(1, d.Y)))
+	phi := math.Atan2(-d.Z, d.X) + math.Pi - DegreesToRadians(e.Rotation)
+	u = phi / (2 * math.Pi)
+	return u - math.Floor(u), theta
+}
+
+// direction is the inverse of uv.
+func (e *EnvironmentMap) direction(u, theta float64) Vec3 {
+	phi := 2*math.Pi*u + DegreesToRadians(e.Rotation) - math.Pi
+	sinTheta := math.Sin(theta)
+	return NewVec3(math.Cos(phi)*sinTheta, math.Cos(theta), -math.Sin(phi)*sinTheta)
+}
+
+func (e *EnvironmentMap) PDFValue(origin, direction Vec3) float64 {
+	if e.rows.total == 0 {
+		return 1 / (4 * math.Pi)
+	}
+	x, y := e.pixel(direction)
+	_, theta := e.uv(direction)
+	sinTheta := math.Sin(theta)
+	if sinTheta <= 0 {
+		return 0
+	}
+	// the density over the unit square of image coordinates, divided by the
+	// solid angle that square spans around each point
+	pdf := e.rows.pdf(y) * e.columns[y].pdf(x) * float64(e.Image.Width*e.Image.Height)
+	return pdf / (2 * math.Pi * math.Pi * sinTheta)
+}
+
+func (e *EnvironmentMap) Random(origin Vec3, rng *rand.Rand) Vec3 {
+	if e.rows.total == 0 {
+		return RandomUnitVector(rng)
+	}
+	y, dy := e.rows.sample(rng.Float64())
+	x, dx := e.columns[y].sample(rng.Float64())
+	u := (float64(x) + dx) / float64(e.Image.Width)
+	theta := math.Pi * (float64(y) + dy) / float64(e.Image.Height)
+	return e.direction(u, theta)
+}
+
+// piecewiseConstant is a 1D distribution over bins in proportion to weights.
+type piecewiseConstant struct {
+	cdf   []float64 // cdf[i] is the chance of a bin before i; the last entry is 1
+	total float64
+}
+
+func newPiecewiseConstant(weights []float64) piecewiseConstant {
+	p := piecewiseConstant{cdf: make([]float64, len(weights)+1)}
+	for i, w := range weights {
+		p.cdf[i+1] = p.cdf[i] + w
+	}
+	p.total = p.cdf[len(weights)]
+	for i := range p.cdf {
+		if p.total > 0 {
+			p.cdf[i] /= p.total
+		} else {
+			p.cdf[i] = float64(i) / float64(len(weights))
+		}
+	}
+	return p
+}
+
+// pdf is the chance of picking bin i.
+func (p piecewiseConstant) pdf(i int) float64 {
+	return p.cdf[i+1] - p.cdf[i]
+}
+
+// sample picks the bin xi falls in and where in it, from 0 to 1.
+func (p piecewiseConstant) sample(xi float64) (int, float64) {
+	i := sort.SearchFloat64s(p.cdf, xi)
+	// SearchFloat64s finds the first cdf >= xi; the bin ends there
+	i = max(min(i-1, len(p.cdf)-2), 0)
+	for p.pdf(i) == 0 && i < len(p.cdf)-2 {
+		i++ // xi sat on the boundary of an empty bin
+	}
+	return i, min((xi-p.cdf[i])/p.pdf(i), math.Nextafter(1, 0))
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"slices"
+)
+
+type Framebuffer struct {
+	Width, Height int
+	Pixels        []Vec3 // linear radiance, row-major starting at the top-left pixel
+}
+
+func NewFramebuffer(w, h int) *Framebuffer {
+	return &Framebuffer{Width: w, Height: h, Pixels: make([]Vec3, w*h)}
+}
+func (fb *Framebuffer) At(x, y int) Vec3 {
+	return fb.Pixels[y*fb.Width+x]
+}
+func (fb *Framebuffer) Set(x, y int, c Vec3) {
+	fb.Pixels[y*fb.Width+x] = c
+}
+
+// Channel copies one color component (0 red, 1 green, 2 blue) as float32s.
+func (fb *Framebuffer) Channel(k int) []float32 {
+	values := make([]float32, len(fb.Pixels))
+	for i, c := range fb.Pixels {
+		values[i] = float32(c.GetDim(k))
+	}
+	return values
+}
+
+// Image converts the linear buffer to 8-bit display values.
+func (fb *Framebuffer) Image(display DisplayTransform) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, fb.Width, fb.Height))
+	for y := range fb.Height {
+		for x := range fb.Width {
+			r, g, b := display.RGB(fb.At(x, y))
+			img.SetNRGBA(x, y, color.NRGBA{R: r, G: g, B: b, A: 255})
+		}
+	}
+	return img
+}
+
+// Accumulator sums radiance samples per pixel, so more can be added to an
+// image that is already usable. Pixels may hold different sample counts.
+// The running mean and variance of each pixel's luminance (Welford's method)
+// decide when adaptive sampling can stop.
+type Accumulator struct {
+	Width, Height int
+	Sum           []Vec3
+	Samples       []int
+	LumMean       []float64
+	LumM2         []float64 // sum of squared deviations from LumMean
+	Converged     []bool
+	AOVs          *AOVBuffers // nil unless the camera records them
+}
+
+func NewAccumulator(w, h int) *Accumulator {
+	n := w * h
+	return &Accumulator{
+		Width: w, Height: h,
+		Sum: make([]Vec3, n), Samples: make([]int, n),
+		LumMean: make([]float64, n), LumM2: make([]float64, n),
+		Converged: make([]bool, n),
+	}
+}
+func (a *Accumulator) Add(x, y int, c Vec3) {
+	i := y*a.Width + x
+	a.Sum[i].PlusEq(c)
+	a.Samples[i]++
+	lum := Luminance(c)
+	delta := lum - a.LumMean[i]
+	a.LumMean[i] += delta / float64(a.Samples[i])
+	a.LumM2[i] += delta * (lum - a.LumMean[i])
+}
+
+// UpdateConverged marks the pixels whose 95% confidence interval on mean
+// luminance is narrower than threshold times the mean, and returns how many
+// are still unconverged. Means below 0.01 count as 0.01, so near-black pixels
+// aren't compared against a vanishing tolerance.
+func (a *Accumulator) UpdateConverged(threshold float64) int {
+	remaining := 0
+	for i, n := range a.Samples {
+		if a.Converged[i] {
+			continue
+		}
+		if n >= 2 {
+			variance := a.LumM2[i] / float64(n-1)
+			halfWidth := 1.96 * math.Sqrt(variance/float64(n))
+			if halfWidth <= threshold*max(a.LumMean[i], 0.01) {
+				a.Converged[i] = true
+				continue
+			}
+		}
+		remaining++
+	}
+	return remaining
+}
+
+// Heatmap shows each pixel's sample count, from black through blue, red and
+// yellow to white at the highest count. It is meant for LegacyDisplay.
+func (a *Accumulator) Heatmap() *Framebuffer {
+	fb := NewFramebuffer(a.Width, a.Height)
+	most := slices.Max(a.Samples)
+	if most == 0 {
+		return fb
+	}
+	ramp := []Vec3{NewVec3(0, 0, 0), NewVec3(0, 0, 1), NewVec3(1, 0, 0), NewVec3(1, 1, 0), NewVec3(1, 1, 1)}
+	for i, n := range a.Samples {
+		t := float64(n) / float64(most) * float64(len(ramp)-1)
+		k := min(int(t), len(ramp)-2)
+		c := ramp[k].Scale(float64(k+1) - t).Add(ramp[k+1].Scale(t - float64(k)))
+		fb.Pixels[i] = c.Mul(c) // display applies gamma 2
+	}
+	return fb
+}
+
+// Framebuffer averages the samples so far; pixels without any stay black.
+func (a *Accumulator) Framebuffer() *Framebuffer {
+	fb := NewFramebuffer(a.Width, a.Height)
+	for i, sum := range a.Sum {
+		if n := a.Samples[i]; n > 0 {
+			fb.Pixels[i] = sum.Scale(1 / float64(n))
+		}
+	}
+	return fb
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReadHDRImage loads a .pfm, .hdr or .exr file, as written by the HDR
+// writers, back into a linear framebuffer.
+func ReadHDRImage(path string) (*Framebuffer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	var fb *Framebuffer
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".pfm":
+		fb, err = ReadPFM(r)
+	case ".hdr":
+		fb, err = ReadRGBE(r)
+	case ".exr":
+		fb, err = ReadEXR(r)
+	default:
+		return nil, fmt.Errorf("%s: not an HDR image (want .pfm, .hdr or .exr)", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return fb, nil
+}
+
+func ReadPFM(r *bufio.Reader) (*Framebuffer, error) {
+	var magic string
+	var width, height int
+	var scale float64
+	if _, err := fmt.Fscan(r, &magic, &width, &height, &scale); err != nil {
+		return nil, fmt.Errorf("invalid PFM header: %w", err)
+	}
+	if _, err := r.ReadByte(); err != nil { // the single whitespace before the data
+		return nil, err
+	}
+	channels := 3
+	switch magic {
+	case "PF":
+	case "Pf":
+		channels = 1
+	default:
+		return nil, fmt.Errorf("not a PFM file")
+	}
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid PFM size %dx%d", width, height)
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if scale < 0 {
+		order = binary.LittleEndian
+	}
+	fb := NewFramebuffer(width, height)
+	row := make([]byte, 4*channels*width)
+	for y := height - 1; y >= 0; y-- {
+		if _, err := io.ReadFull(r, row); err != nil {
+			return nil, fmt.Errorf("truncated PFM data: %w", err)
+		}
+		for x := range width {
+			var c [3]float64
+			for k := range 3 {
+				bits := order.Uint32(row[4*(channels*x+min(k, channels-1)):])
+				c[k] = float64(math.Float32frombits(bits))
+			}
+			fb.Set(x, y, NewVec3(c[0], c[1], c[2]))
+		}
+	}
+	return fb, nil
+}
+
+func ReadRGBE(r *bufio.Reader) (*Framebuffer, error) {
+	first, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(first, "#?") {
+		return nil, errors.New("not a Radiance HDR file")
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, errors.New("truncated HDR header")
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if format, ok := strings.CutPrefix(line, "FORMAT="); ok && format != "32-bit_rle_rgbe" {
+			return nil, fmt.Errorf("unsupported HDR format %s", format)
+		}
+	}
+	var width, height int
+	resolution, err := r.ReadString('\n')
+	if err != nil {
+		return nil, errors.New("missing HDR resolution")
+	}
+	if _, err := fmt.Sscanf(resolution, "-Y %d +X %d", &height, &width); err != nil || width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("unsupported HDR orientation %q", strings.TrimSpace(resolution))
+	}
+
+	fb := NewFramebuffer(width, height)
+	scanline := make([]byte, 4*width)
+	for y := range height {
+		if err := readRGBEScanline(r, scanline, width); err != nil {
+			return nil, fmt.Errorf("scanline %d: %w", y, err)
+		}
+		for x := range width {
+			fb.Set(x, y, FromRGBE([4]byte(scanline[4*x:4*x+4])))
+		}
+	}
+	return fb, nil
+}
+
+// readRGBEScanline reads one run-length encoded or flat scanline into
+// interleaved RGBE bytes.
+func readRGBEScanline(r *bufio.Reader, scanline []byte, width int) error {
+	head, err := r.Peek(4)
+	if err != nil {
+		return err
+	}
+	if width < 8 || width > 0x7fff || head[0] != 2 || head[1] != 2 || head[2]&0x80 != 0 {
+		_, err := io.ReadFull(r, scanline)
+		return err
+	}
+	if int(head[2])<<8|int(head[3]) != width {
+		return errors.New("scanline width mismatch")
+	}
+	r.Discard(4)
+	for k := range 4 {
+		for x := 0; x < width; {
+			count, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+			if count > 128 {
+				n := int(count) - 128
+				value, err := r.ReadByte()
+				if err != nil {
+					return err
+				}
+				if x+n > width {
+					return errors.New("run overflows scanline")
+				}
+				for range n {
+					scanline[4*x+k] = value
+					x++
+				}
+				continue
+			}
+			if count == 0 || x+int(count) > width {
+				return errors.New("invalid run length")
+			}
+			for range count {
+				b, err := r.ReadByte()
+				if err != nil {
+					return err
+				}
+				scanline[4*x+k] = b
+				x++
+			}
+		}
+	}
+	return nil
+}
+
+// FromRGBE unpacks a shared-exponent pixel, taking each mantissa at the
+// middle of its step.
+func FromRGBE(rgbe [4]byte) Vec3 {
+	if rgbe[3] == 0 {
+		return NewVec3(0, 0, 0)
+	}
+	f := math.Ldexp(1, int(rgbe[3])-(128+8))
+	return NewVec3((float64(rgbe[0])+0.5)*f, (float64(rgbe[1])+0.5)*f, (float64(rgbe[2])+0.5)*f)
+}
+
+// ReadEXR reads scanline OpenEXR files with uncompressed, ZIPS or ZIP data
+// and half or float R, G and B (or Y) channels.
+func ReadEXR(r *bufio.Reader) (*Framebuffer, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 || !bytes.Equal(data[:4], []byte{0x76, 0x2f, 0x31, 0x01}) {
+		return nil, errors.New("not an OpenEXR file")
+	}
+	if data[4] != 2 || data[5]&0x1a != 0 { // tiled, deep or multi-part
+		return nil, errors.New("only single-part scanline OpenEXR files are supported")
+	}
+
+	type channel struct {
+		name      string
+		pixelType uint32 // 1 is half, 2 is float
+	}
+	var channels []channel
+	compression := -1
+	var window [4]int32
+	pos := 8
+	cstring := func() (string, error) {
+		end := bytes.IndexByte(data[pos:], 0)
+		if end < 0 {
+			return "", errors.New("truncated header")
+		}
+		s := string(data[pos : pos+end])
+		pos += end + 1
+		return s, nil
+	}
+	for {
+		name, err := cstring()
+		if err != nil {
+			return nil, err
+		}
+		if name == "" {
+			break
+		}
+		typ, err := cstring()
+		if err != nil {
+			return nil, err
+		}
+		if pos+4 > len(data) {
+			return nil, errors.New("truncated header")
+		}
+		size := int(binary.LittleEndian.Uint32(data[pos:]))
+		pos += 4
+		if pos+size > len(data) {
+			return nil, errors.New("truncated header")
+		}
+		value := data[pos : pos+size]
+		pos += size
+		switch {
+		case name == "channels" && typ == "chlist":
+			for len(value) > 1 {
+				end := bytes.IndexByte(value, 0)
+				if end < 0 || len(value) < end+17 {
+					return nil, errors.New("invalid channel list")
+				}
+				c := channel{name: string(value[:end]), pixelType: binary.LittleEndian.Uint32(value[end+1:])}
+				if binary.LittleEndian.Uint32(value[end+9:]) != 1 || binary.LittleEndian.Uint32(value[end+13:]) != 1 {
+					return nil, fmt.Errorf("subsampled channel %s is not supported", c.name)
+				}
+				if c.pixelType != 1 && c.pixelType != 2 {
+					return nil, fmt.Errorf("channel %s: only half and float pixels are supported", c.name)
+				}
+				channels = append(channels, c)
+				value = value[end+17:]
+			}
+		case name == "compression" && len(value) == 1:
+			compression = int(value[0])
+		case name == "dataWindow" && typ == "box2i" && len(value) == 16:
+			binary.Read(bytes.NewReader(value), binary.LittleEndian, &window)
+		}
+	}
+	width, height := int(window[2]-window[0])+1, int(window[3]-window[1])+1
+	if len(channels) == 0 || width <= 0 || height <= 0 {
+		return nil, errors.New("missing channels or data window")
+	}
+	linesPerChunk := 0
+	switch compression {
+	case 0, 2:
+		linesPerChunk = 1
+	case 3:
+		linesPerChunk = 16
+	default:
+		return nil, fmt.Errorf("unsupported compression %d (want none, ZIPS or ZIP)", compression)
+	}
+
+	planes := map[string][]float64{}
+	bytesPerLine := 0
+	for _, c := range channels {
+		planes[c.name] = make([]float64, width*height)
+		bytesPerLine += width * int(2*c.pixelType)
+	}
+	chunks := (height + linesPerChunk - 1) / linesPerChunk
+	if pos+8*chunks > len(data) {
+		return nil, errors.New("truncated offset table")
+	}
+	for i := range chunks {
+		offset := int(binary.LittleEndian.Uint64(data[pos+8*i:]))
+		if offset < 0 || offset+8 > len(data) {
+			return nil, errors.New("chunk offset out of range")
+		}
+		y := int(int32(binary.LittleEndian.Uint32(data[offset:]))) - int(window[1])
+		size := int(binary.LittleEndian.Uint32(data[offset+4:]))
+		if y < 0 || y >= height || offset+8+size > len(data) {
+			return nil, errors.New("invalid chunk")
+		}
+		lines := min(linesPerChunk, height-y)
+		raw := data[offset+8 : offset+8+size]
+		if want := lines * bytesPerLine; size < want {
+			if raw, err = exrUnzip(raw, want); err != nil {
+				return nil, err
+			}
+		} else if size != want {
+			return nil, errors.New("chunk size mismatch")
+		}
+		for line := range lines {
+			for _, c := range channels {
+				plane := planes[c.name][(y+line)*width:]
+				for x := range width {
+					if c.pixelType == 2 {
+						plane[x] = float64(math.Float32frombits(binary.LittleEndian.Uint32(raw)))
+						raw = raw[4:]
+					} else {
+						plane[x] = halfToFloat(binary.LittleEndian.Uint16(raw))
+						raw = raw[2:]
+					}
+				}
+			}
+		}
+	}
+
+	red, green, blue := planes["R"], planes["G"], planes["B"]
+	if red == nil || green == nil || blue == nil {
+		if planes["Y"] == nil {
+			return nil, errors.New("no R, G, B or Y channels")
+		}
+		red, green, blue = planes["Y"], planes["Y"], planes["Y"]
+	}
+	fb := NewFramebuffer(width, height)
+	for i := range fb.Pixels {
+		fb.Pixels[i] = NewVec3(red[i], green[i], blue[i])
+	}
+	return fb, nil
+}
+
+// exrUnzip undoes exrZip.
+func exrUnzip(compressed []byte, size int) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	tmp := make([]byte, size)
+	if _, err := io.ReadFull(zr, tmp); err != nil {
+		return nil, fmt.Errorf("corrupt ZIP chunk: %w", err)
+	}
+	for i := 1; i < len(tmp); i++ {
+		tmp[i] += tmp[i-1] - 128
+	}
+	raw := make([]byte, size)
+	half := (size + 1) / 2
+	for i := range raw {
+		if i%2 == 0 {
+			raw[i] = tmp[i/2]
+		} else {
+			raw[i] = tmp[half+i/2]
+		}
+	}
+	return raw, nil
+}
+
+func halfToFloat(h uint16) float64 {
+	sign := 1.0
+	if h&0x8000 != 0 {
+		sign = -1
+	}
+	exp := int(h>>10) & 0x1f
+	mantissa := float64(h & 0x3ff)
+	switch exp {
+	case 0:
+		return sign * math.Ldexp(mantissa, -24)
+	case 0x1f:
+		if mantissa == 0 {
+			return sign * math.Inf(1)
+		}
+		return math.NaN()
+	}
+	return sign * math.Ldexp(1+mantissa/1024, exp-15)
+}
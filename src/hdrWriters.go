@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// The HDR writers store the framebuffer's linear radiance as is, without the
+// clamp and gamma of the 8-bit formats.
+
+type PFMWriter struct{} // Portable Float Map, 32-bit RGB
+
+func (PFMWriter) WriteImage(w io.Writer, fb *Framebuffer) error {
+	out := bufio.NewWriter(w)
+	// a negative scale marks little-endian data; rows run bottom to top
+	fmt.Fprintf(out, "PF\n%d %d\n-1.0\n", fb.Width, fb.Height)
+	row := make([]byte, 12*fb.Width)
+	for y := fb.Height - 1; y >= 0; y-- {
+		for x := range fb.Width {
+			c := fb.At(x, y)
+			binary.LittleEndian.PutUint32(row[12*x:], math.Float32bits(float32(c.X)))
+			binary.LittleEndian.PutUint32(row[12*x+4:], math.Float32bits(float32(c.Y)))
+			binary.LittleEndian.PutUint32(row[12*x+8:], math.Float32bits(float32(c.Z)))
+		}
+		out.Write(row)
+	}
+	return out.Flush()
+}
+
+type RGBEWriter struct{} // Radiance .hdr, run-length encoded
+
+func (RGBEWriter) WriteImage(w io.Writer, fb *Framebuffer) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n-Y %d +X %d\n", fb.Height, fb.Width)
+	scanline := make([]byte, 4*fb.Width)
+	channel := make([]byte, fb.Width)
+	var encoded []byte
+	for y := range fb.Height {
+		for x := range fb.Width {
+			rgbe := ToRGBE(fb.At(x, y))
+			copy(scanline[4*x:], rgbe[:])
+		}
+		if fb.Width < 8 || fb.Width > 0x7fff {
+			out.Write(scanline) // too narrow or wide for run-length scanlines
+			continue
+		}
+		out.Write([]byte{2, 2, byte(fb.Width >> 8), byte(fb.Width)})
+		for k := range 4 {
+			for x := range fb.Width {
+				channel[x] = scanline[4*x+k]
+			}
+			encoded = rleEncode(encoded[:0], channel)
+			out.Write(encoded)
+		}
+	}
+	return out.Flush()
+}
+
+// rgbeMax is the brightest component RGBE can hold: a full mantissa at the
+// largest exponent.
+var rgbeMax = math.Ldexp(255, 127-8)
+
+// ToRGBE packs a color into three 8-bit mantissas sharing the exponent of
+// the brightest component. NaN and negative components are stored as 0 and
+// anything too bright, +Inf included, as rgbeMax.
+func ToRGBE(c Vec3) [4]byte {
+	clamp := func(v float64) float64 {
+		if math.IsNaN(v) {
+			return 0
+		}
+		return min(max(v, 0), rgbeMax)
+	}
+	r, g, b := clamp(c.X), clamp(c.Y), clamp(c.Z)
+	brightest := max(r, g, b)
+	if brightest < 1e-32 {
+		return [4]byte{}
+	}
+	frac, exp := math.Frexp(brightest)
+	scale := frac * 256 / brightest
+	return [4]byte{byte(r * scale), byte(g * scale), byte(b * scale), byte(exp + 128)}
+}
+
+// rleEncode appends data in Radiance's scanline run-length code: a byte above
+// 128 repeats the next byte that many times less 128, any other count is
+// followed by that many literal bytes.
+func rleEncode(out, data []byte) []byte {
+	const minRun = 4
+	runLength := func(i int) int {
+		n := 1
+		for i+n < len(data) && n < 127 && data[i+n] == data[i] {
+			n++
+		}
+		return n
+	}
+	for i := 0; i < len(data); {
+		if n := runLength(i); n >= minRun {
+			out = append(out, byte(128+n), data[i])
+			i += n
+			continue
+		}
+		start := i
+		for i < len(data) && i-start < 128 && runLength(i) < minRun {
+			i++
+		}
+		out = append(out, byte(i-start))
+		out = append(out, data[start:i]...)
+	}
+	return out
+}
+
+type EXRCompression byte
+
+const (
+	EXRNoCompression  EXRCompression = 0
+	EXRZipCompression EXRCompression = 3 // zlib over blocks of 16 scanlines
+)
+
+// EXRWriter writes a scanline OpenEXR file with float32 R, G and B channels.
+type EXRWriter struct {
+	Compression EXRCompression
+}
+
+func (e EXRWriter) WriteImage(w io.Writer, fb *Framebuffer) error {
+	channels := []EXRChannel{
+		{Name: "R", Values: fb.Channel(0)},
+		{Name: "G", Values: fb.Channel(1)},
+		{Name: "B", Values: fb.Channel(2)},
+	}
+	return WriteEXR(w, fb.Width, fb.Height, channels, e.Compression)
+}
+
+// EXRChannel is one float32 image plane, row-major from the top-left pixel.
+// Layers are named with a prefix, like "albedo.R".
+type EXRChannel struct {
+	Name   string
+	Values []float32
+}
+
+func WriteEXR(w io.Writer, width, height int, channels []EXRChannel, compression EXRCompression) error {
+	// readers expect channels sorted by name
+	channels = append([]EXRChannel(nil), channels...)
+	sort.Slice(channels, func(i, j int) bool { return channels[i].Name < channels[j].Name })
+	for _, c := range channels {
+		if len(c.Values) != width*height {
+			return fmt.Errorf("exr channel %s has %d values, want %d", c.Name, len(c.Values), width*height)
+		}
+	}
+
+	var header bytes.Buffer
+	header.Write([]byte{0x76, 0x2f, 0x31, 0x01}) // magic number
+	binary.Write(&header, binary.LittleEndian, int32(2))
+	attribute := func(name, typ string, value []byte) {
+		header.WriteString(name + "\x00" + typ + "\x00")
+		binary.Write(&header, binary.LittleEndian, int32(len(value)))
+		header.Write(value)
+	}
+	le := func(values ...any) []byte {
+		var b bytes.Buffer
+		for _, v := range values {
+			binary.Write(&b, binary.LittleEndian, v)
+		}
+		return b.Bytes()
+	}
+	var chlist bytes.Buffer
+	for _, c := range channels {
+		chlist.WriteString(c.Name + "\x00")
+		// FLOAT pixels, not perceptually linear, x and y sampling of 1
+		chlist.Write(le(int32(2), [4]byte{}, int32(1), int32(1)))
+	}
+	chlist.WriteByte(0)
+	window := le(int32(0), int32(0), int32(width-1), int32(height-1))
+	attribute("channels", "chlist", chlist.Bytes())
+	attribute("compression", "compression", []byte{byte(compression)})
+	attribute("dataWindow", "box2i", window)
+	attribute("displayWindow", "box2i", window)
+	attribute("lineOrder", "lineOrder", []byte{0}) // increasing y
+	attribute("pixelAspectRatio", "float", le(float32(1)))
+	attribute("screenWindowCenter", "v2f", le(float32(0), float32(0)))
+	attribute("screenWindowWidth", "float", le(float32(1)))
+	header.WriteByte(0)
+
+	linesPerChunk := 1
+	if compression == EXRZipCompression {
+		linesPerChunk = 16
+	}
+	var chunks [][]byte
+	for y0 := 0; y0 < height; y0 += linesPerChunk {
+		y1 := min(y0+linesPerChunk, height)
+		// each scanline holds every channel's row in turn
+		raw := make([]byte, 0, 4*len(channels)*width*(y1-y0))
+		for y := y0; y < y1; y++ {
+			for _, c := range channels {
+				for _, v := range c.Values[y*width : (y+1)*width] {
+					raw = binary.LittleEndian.AppendUint32(raw, math.Float32bits(v))
+				}
+			}
+		}
+		data := raw
+		if compression == EXRZipCompression {
+			compressed, err := exrZip(raw)
+			if err != nil {
+				return err
+			}
+			if len(compressed) < len(raw) { // otherwise the chunk is stored as is
+				data = compressed
+			}
+		}
+		chunk := le(int32(y0), int32(len(data)))
+		chunks = append(chunks, append(chunk, data...))
+	}
+
+	out := bufio.NewWriter(w)
+	out.Write(header.Bytes())
+	offset := uint64(header.Len() + 8*len(chunks))
+	for _, chunk := range chunks {
+		binary.Write(out, binary.LittleEndian, offset)
+		offset += uint64(len(chunk))
+	}
+	for _, chunk := range chunks {
+		out.Write(chunk)
+	}
+	return out.Flush()
+}
+
+// exrZip reorders the bytes as OpenEXR's ZIP codec expects, even-indexed
+// bytes first and then deltas of the whole, before deflating them.
+func exrZip(raw []byte) ([]byte, error) {
+	tmp := make([]byte, len(raw))
+	half := (len(raw) + 1) / 2
+	for i, b := range raw {
+		if i%2 == 0 {
+			tmp[i/2] = b
+		} else {
+			tmp[half+i/2] = b
+		}
+	}
+	prev := tmp[0]
+	for i := 1; i < len(tmp); i++ {
+		cur := tmp[i]
+		tmp[i] = cur - prev + 128
+		prev = cur
+	}
+
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(tmp); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
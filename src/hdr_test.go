@@ -0,0 +1,109 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// hdrTestImage has dark, bright, black and flat regions so the run-length
+// coders see both runs and literals.
+func hdrTestImage(width, height int) *Framebuffer {
+	fb := NewFramebuffer(width, height)
+	rng := NewRNG(3, 0)
+	for y := range height {
+		for x := range width {
+			switch {
+			case x < width/4:
+				fb.Set(x, y, NewVec3(0.25, 0.5, 1))
+			case y%5 == 0:
+				fb.Set(x, y, Vec3{})
+			default:
+				scale := math.Pow(10, 8*rng.Float64()-4)
+				fb.Set(x, y, NewVec3(rng.Float64(), rng.Float64(), rng.Float64()).Scale(scale))
+			}
+		}
+	}
+	return fb
+}
+
+func TestHDRRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		ext    string
+		writer ImageWriter
+		exact  bool // false when the format has less precision than float32
+	}{
+		{".pfm", PFMWriter{}, true},
+		{".hdr", RGBEWriter{}, false},
+		{".exr", EXRWriter{}, true},
+		{".exr", EXRWriter{Compression: EXRZipCompression}, true},
+	} {
+		// narrow images skip RGBE run-length scanlines; tall ones span
+		// several EXR ZIP blocks
+		for _, size := range [][2]int{{1, 1}, {5, 3}, {37, 40}} {
+			fb := hdrTestImage(size[0], size[1])
+			path := filepath.Join(t.TempDir(), "image"+test.ext)
+			f, err := os.Create(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := test.writer.WriteImage(f, fb); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadHDRImage(path)
+			if err != nil {
+				t.Fatalf("%T %v: %v", test.writer, size, err)
+			}
+			if got.Width != fb.Width || got.Height != fb.Height {
+				t.Fatalf("%T %v: read a %dx%d image", test.writer, size, got.Width, got.Height)
+			}
+			for i, want := range fb.Pixels {
+				if !hdrClose(got.Pixels[i], want, test.exact) {
+					t.Fatalf("%T %v: pixel %d is %v, want %v", test.writer, size, i, got.Pixels[i], want)
+				}
+			}
+		}
+	}
+}
+
+// hdrClose compares to float32 precision or, for RGBE, to the 8-bit
+// mantissa shared with the brightest component.
+func hdrClose(got, want Vec3, exact bool) bool {
+	tolerance := 0.0
+	if !exact {
+		tolerance = max(want.X, want.Y, want.Z) / 128
+	}
+	for _, c := range [][2]float64{{got.X, want.X}, {got.Y, want.Y}, {got.Z, want.Z}} {
+		if exact && c[0] != float64(float32(c[1])) || !exact && math.Abs(c[0]-c[1]) > tolerance {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRGBEOfBlack(t *testing.T) {
+	if c := FromRGBE(ToRGBE(Vec3{})); c != (Vec3{}) {
+		t.Errorf("black came back as %v", c)
+	}
+}
+
+func TestRGBEOfNonFinite(t *testing.T) {
+	inf, nan := math.Inf(1), math.NaN()
+	for _, test := range []struct {
+		in, want Vec3
+	}{
+		{NewVec3(nan, nan, nan), Vec3{}},
+		{NewVec3(nan, 1, 0.5), NewVec3(0, 1, 0.5)},
+		{NewVec3(math.Inf(-1), 1, 1), NewVec3(0, 1, 1)},
+		{NewVec3(inf, 1, 0), NewVec3(rgbeMax, 0, 0)},
+		{NewVec3(1e300, 1e300, 1e300), NewVec3(rgbeMax, rgbeMax, rgbeMax)},
+	} {
+		if got := FromRGBE(ToRGBE(test.in)); !hdrClose(got, test.want, false) {
+			t.Errorf("%v came back as %v, want %v", test.in, got, test.want)
+		}
+	}
+}
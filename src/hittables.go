@@ -13,6 +13,7 @@ type HitRecord struct {
 	P               Vec3
 	Normal          Vec3
 	MaterialPointer *Material
+	Object          any // the primitive or mesh that was hit, for ID buffers
 }
 
 func (h *HitRecord) SetFaceNormal(r Ray, outwardNormal Vec3) {
@@ -25,7 +26,7 @@ func (h *HitRecord) SetFaceNormal(r Ray, outwardNormal Vec3) {
 }
 
 type Hittable interface {
-	Hit(r Ray, i *Interval, rec *HitRecord) bool
+	Hit(r Ray, i *Interval, rec *HitRecord, rng *rand.Rand) bool
 	BBOX() *AABB
 }
 
@@ -48,12 +49,12 @@ func (hl *HittableList) Add(h *Hittable) {
 	hl.Objects = append(hl.Objects, h)
 	hl.BBOXField.MergeAABB((*h).BBOX())
 }
-func (hl *HittableList) Hit(r Ray, i *Interval, rec *HitRecord) bool {
+func (hl *HittableList) Hit(r Ray, i *Interval, rec *HitRecord, rng *rand.Rand) bool {
 	var temp HitRecord
 	hitAnything := false
 	closestSoFar := i.Max
 	for _, hittableObject := range hl.Objects {
-		if (*hittableObject).Hit(r, NewInterval(i.Min, closestSoFar), &temp) {
+		if (*hittableObject).Hit(r, NewInterval(i.Min, closestSoFar), &temp, rng) {
 			hitAnything = true
 			closestSoFar = temp.T
 			*rec = temp
@@ -88,7 +89,7 @@ func NewMovingSphere(c1, c2 Vec3, r float64, mat *Material) *Hittable {
 	h := Hittable(&Sphere{Center: center, Radius: max(0, r), Mat: mat, BBOXField: box1})
 	return &h
 }
-func (s *Sphere) Hit(r Ray, i *Interval, rec *HitRecord) bool {
+func (s *Sphere) Hit(r Ray, i *Interval, rec *HitRecord, rng *rand.Rand) bool {
 	currentCenter := s.Center.at(r.Time)
 	oc := currentCenter.Sub(r.Origin)
 
@@ -115,6 +116,7 @@ func (s *Sphere) Hit(r Ray, i *Interval, rec *HitRecord) bool {
 	rec.SetFaceNormal(r, outwardNormal)
 	GetSphereUV(outwardNormal, &rec.U, &rec.V)
 	rec.MaterialPointer = s.Mat
+	rec.Object = s
 
 	return true
 }
@@ -155,7 +157,7 @@ func (q *Quad) SetBoundingBox() {
 	q.BBOXField = bboxDiag1
 }
 
-func (q *Quad) Hit(r Ray, i *Interval, rec *HitRecord) bool {
+func (q *Quad) Hit(r Ray, i *Interval, rec *HitRecord, rng *rand.Rand) bool {
 	denominator := Dot(&q.Normal, &r.Direction)
 	if math.Abs(denominator) < 1e-8 {
 		return false
@@ -177,6 +179,7 @@ func (q *Quad) Hit(r Ray, i *Interval, rec *HitRecord) bool {
 	rec.T = t
 	rec.P = intersection
 	rec.MaterialPointer = q.Mat
+	rec.Object = q
 	rec.SetFaceNormal(r, q.Normal)
 	return true
 }
@@ -245,14 +248,14 @@ func NewConstantMediumFromColor(boundary *Hittable, density float64, c Vec3) *Hi
 	return &h
 }
 
-func (c *ConstantMedium) Hit(r Ray, i *Interval, rec *HitRecord) bool {
+func (c *ConstantMedium) Hit(r Ray, i *Interval, rec *HitRecord, rng *rand.Rand) bool {
 	var rec1, rec2 HitRecord
 
-	if !(*c.Boundary).Hit(r, UniverseInterval, &rec1) {
+	if !(*c.Boundary).Hit(r, UniverseInterval, &rec1, rng) {
 		return false
 	}
 
-	if !(*c.Boundary).Hit(r, NewInterval(rec1.T+0.0001, math.Inf(1)), &rec2) {
+	if !(*c.Boundary).Hit(r, NewInterval(rec1.T+0.0001, math.Inf(1)), &rec2, rng) {
 		return false
 	}
 
@@ -267,7 +270,7 @@ func (c *ConstantMedium) Hit(r Ray, i *Interval, rec *HitRecord) bool {
 
 	rayLength := r.Direction.Length()
 	distanceInsideBoundary := (rec2.T - rec1.T) * rayLength
-	hitDistance := c.NegInvDensity * math.Log(rand.Float64())
+	hitDistance := c.NegInvDensity * math.Log(rng.Float64())
 
 	if hitDistance > distanceInsideBoundary {
 		return false
@@ -279,6 +282,7 @@ func (c *ConstantMedium) Hit(r Ray, i *Interval, rec *HitRecord) bool {
 	rec.Normal = NewVec3(1, 0, 0)
 	rec.FrontFace = true
 	rec.MaterialPointer = c.PhaseFunction
+	rec.Object = c
 
 	return true
 
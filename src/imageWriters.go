@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type ImageWriter interface {
+	WriteImage(w io.Writer, fb *Framebuffer) error
+}
+
+// The 8-bit writers tone map through their Display transform.
+
+type PPMWriter struct { // ASCII P3
+	Display DisplayTransform
+}
+
+func (p PPMWriter) WriteImage(w io.Writer, fb *Framebuffer) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "P3\n%d %d\n255\n", fb.Width, fb.Height)
+	for _, c := range fb.Pixels {
+		r, g, b := p.Display.RGB(c)
+		fmt.Fprintf(out, "%d %d %d\n", r, g, b)
+	}
+	return out.Flush()
+}
+
+type BinaryPPMWriter struct { // P6
+	Display DisplayTransform
+}
+
+func (p BinaryPPMWriter) WriteImage(w io.Writer, fb *Framebuffer) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "P6\n%d %d\n255\n", fb.Width, fb.Height)
+	for _, c := range fb.Pixels {
+		r, g, b := p.Display.RGB(c)
+		out.Write([]byte{r, g, b})
+	}
+	return out.Flush()
+}
+
+type PNGWriter struct {
+	Display DisplayTransform
+}
+
+func (p PNGWriter) WriteImage(w io.Writer, fb *Framebuffer) error {
+	return png.Encode(w, fb.Image(p.Display))
+}
+
+// IsHDRWriter reports whether writer stores linear radiance rather than
+// display values.
+func IsHDRWriter(writer ImageWriter) bool {
+	switch writer.(type) {
+	case PFMWriter, RGBEWriter, EXRWriter:
+		return true
+	}
+	return false
+}
+
+// WithDisplay sets the display transform of an 8-bit writer. HDR writers
+// store radiance untouched and are returned as they are.
+func WithDisplay(writer ImageWriter, display DisplayTransform) ImageWriter {
+	switch w := writer.(type) {
+	case PPMWriter:
+		w.Display = display
+		return w
+	case BinaryPPMWriter:
+		w.Display = display
+		return w
+	case PNGWriter:
+		w.Display = display
+		return w
+	}
+	return writer
+}
+
+// ImageWriterForPath picks a writer from the file extension: .ppm is ASCII P3,
+// .pnm is binary P6 and .png is PNG; .pfm, .hdr and .exr (ZIP compressed)
+// keep the linear radiance.
+func ImageWriterForPath(path string) (ImageWriter, error) {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	if ext == "" {
+		return nil, fmt.Errorf("no image writer for %q", path)
+	}
+	writer, err := ImageWriterForFormat(ext)
+	if err != nil {
+		return nil, fmt.Errorf("no image writer for %q", path)
+	}
+	return writer, nil
+}
+
+var ImageFormats = []string{"ppm", "pnm", "png", "pfm", "hdr", "exr", "exr-raw"}
+
+func ImageWriterForFormat(format string) (ImageWriter, error) {
+	switch strings.ToLower(format) {
+	case "ppm", "p3":
+		return PPMWriter{}, nil
+	case "pnm", "p6":
+		return BinaryPPMWriter{}, nil
+	case "png":
+		return PNGWriter{}, nil
+	case "pfm":
+		return PFMWriter{}, nil
+	case "hdr", "rgbe":
+		return RGBEWriter{}, nil
+	case "exr":
+		return EXRWriter{Compression: EXRZipCompression}, nil
+	case "exr-raw":
+		return EXRWriter{Compression: EXRNoCompression}, nil
+	}
+	return nil, fmt.Errorf("unknown image format %q (want one of %s)", format, strings.Join(ImageFormats, ", "))
+}
+
+func SaveImage(path string, fb *Framebuffer) error {
+	writer, err := ImageWriterForPath(path)
+	if err != nil {
+		return err
+	}
+	return WriteImageFile(path, fb, writer)
+}
+
+func WriteImageFile(path string, fb *Framebuffer, writer ImageWriter) error {
+	return WriteFileAtomic(path, func(w io.Writer) error {
+		return writer.WriteImage(w, fb)
+	})
+}
+
+// WriteFileAtomic writes to a temporary file next to path and renames it into
+// place, so a viewer watching path never sees a half-written snapshot and a
+// crash never leaves a truncated checkpoint.
+func WriteFileAtomic(path string, write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // fails harmlessly once renamed
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
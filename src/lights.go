@@ -0,0 +1,166 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+)
+
+// Light is an emitter that can be sampled directly from a shading point.
+type Light interface {
+	// PDFValue is the solid-angle density of Random producing direction from origin.
+	PDFValue(origin, direction Vec3) float64
+	Random(origin Vec3, rng *rand.Rand) Vec3
+}
+
+type LightList struct {
+	Lights []Light
+}
+
+// CollectLights finds the Quad and Sphere emitters of a world, looking through
+// nested lists and BVHs. Transformed and moving lights aren't in world space
+// at a fixed position, so they are still only found by random bounces.
+func CollectLights(world *HittableList) *LightList {
+	lights := &LightList{}
+	seen := map[Hittable]bool{}
+	var walk func(h Hittable)
+	walk = func(h Hittable) {
+		if seen[h] {
+			return
+		}
+		seen[h] = true
+		switch obj := h.(type) {
+		case *HittableList:
+			for _, child := range obj.Objects {
+				walk(*child)
+			}
+		case *BVHNode:
+			walk(*obj.Left)
+			walk(*obj.Right)
+		case *BVHLeaf:
+			for _, child := range obj.Objects {
+				walk(*child)
+			}
+		case *LinearBVH:
+			for _, child := range obj.Primitives {
+				walk(child)
+			}
+		case *Quad:
+			if IsEmissive(obj.Mat) {
+				lights.Lights = append(lights.Lights, obj)
+			}
+		case *Sphere:
+			if IsEmissive(obj.Mat) && obj.Center.Direction.NearZero() {
+				lights.Lights = append(lights.Lights, obj)
+			}
+		}
+	}
+	walk(world)
+	return lights
+}
+
+func IsEmissive(m *Material) bool {
+	_, ok := (*m).(*DiffuseLight)
+	return ok
+}
+
+func (l *LightList) PDFValue(origin, direction Vec3) float64 {
+	if len(l.Lights) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, light := range l.Lights {
+		sum += light.PDFValue(origin, direction)
+	}
+	return sum / float64(len(l.Lights))
+}
+
+func (l *LightList) Random(origin Vec3, rng *rand.Rand) Vec3 {
+	return l.Lights[rng.IntN(len(l.Lights))].Random(origin, rng)
+}
+
+// PowerHeuristic is Veach's multiple importance sampling weight (beta = 2) for
+// a sample drawn from the strategy with density pdfA.
+func PowerHeuristic(pdfA, pdfB float64) float64 {
+	a, b := pdfA*pdfA, pdfB*pdfB
+	if a+b == 0 {
+		return 0
+	}
+	return a / (a + b)
+}
+
+func (q *Quad) PDFValue(origin, direction Vec3) float64 {
+	var rec HitRecord
+	if !q.Hit(NewRay(origin, direction, 0), NewInterval(0.001, math.Inf(1)), &rec, nil) {
+		return 0
+	}
+	n := Cross(&q.U, &q.V)
+	area := n.Length()
+	distanceSquared := rec.T * rec.T * direction.LengthSquared()
+	cosine := math.Abs(Dot(&direction, &q.Normal) / direction.Length())
+	if cosine < 1e-8 {
+		return 0
+	}
+	return distanceSquared / (cosine * area)
+}
+
+func (q *Quad) Random(origin Vec3, rng *rand.Rand) Vec3 {
+	p := q.Q.Add(q.U.Scale(rng.Float64())).Add(q.V.Scale(rng.Float64()))
+	return p.Sub(origin)
+}
+
+// Spheres are sampled by the cone they subtend; from inside, uniformly.
+func (s *Sphere) PDFValue(origin, direction Vec3) float64 {
+	var rec HitRecord
+	if !s.Hit(NewRay(origin, direction, 0), NewInterval(0.001, math.Inf(1)), &rec, nil) {
+		return 0
+	}
+	center := s.Center.at(0)
+	toCenter := center.Sub(origin)
+	distanceSquared := toCenter.LengthSquared()
+	if distanceSquared <= s.Radius*s.Radius {
+		return 1 / (4 * math.Pi)
+	}
+	cosThetaMax := math.Sqrt(1 - s.Radius*s.Radius/distanceSquared)
+	return 1 / (2 * math.Pi * (1 - cosThetaMax))
+}
+
+func (s *Sphere) Random(origin Vec3, rng *rand.Rand) Vec3 {
+	direction := s.Center.at(0).Sub(origin)
+	distanceSquared := direction.LengthSquared()
+	if distanceSquared <= s.Radius*s.Radius {
+		return RandomUnitVector(rng)
+	}
+	return NewONB(direction).Transform(RandomToSphere(rng, s.Radius, distanceSquared))
+}
+
+// RandomToSphere samples a direction, around +Z, inside the cone subtended by
+// a sphere of the given radius at the given squared distance.
+func RandomToSphere(rng *rand.Rand, radius, distanceSquared float64) Vec3 {
+	r1, r2 := rng.Float64(), rng.Float64()
+	z := 1 + r2*(math.Sqrt(1-radius*radius/distanceSquared)-1)
+	phi := 2 * math.Pi * r1
+	x := math.Cos(phi) * math.Sqrt(1-z*z)
+	y := math.Sin(phi) * math.Sqrt(1-z*z)
+	return NewVec3(x, y, z)
+}
+
+// ONB is an orthonormal basis with W along a given direction.
+type ONB struct {
+	U, V, W Vec3
+}
+
+func NewONB(n Vec3) ONB {
+	w := n.GetUnitVec()
+	a := NewVec3(1, 0, 0)
+	if math.Abs(w.X) > 0.9 {
+		a = NewVec3(0, 1, 0)
+	}
+	v := Cross(&w, &a).GetUnitVec()
+	u := Cross(&w, &v)
+	return ONB{U: u, V: v, W: w}
+}
+
+// Transform maps local coordinates into the basis.
+func (o ONB) Transform(v Vec3) Vec3 {
+	return o.U.Scale(v.X).Add(o.V.Scale(v.Y)).Add(o.W.Scale(v.Z))
+}
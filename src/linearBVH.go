@@ -0,0 +1,143 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+)
+
+// LinearBVH is a BVH flattened into a slice in depth-first order, so an
+// interior node's first child is the node right after it. Traversal is a
+// loop over indices instead of recursion through interface pointers.
+type LinearBVH struct {
+	Nodes      []LinearBVHNode
+	Primitives []Hittable // leaves index into this by offset
+	BBOXField  *AABB
+}
+
+type LinearBVHNode struct {
+	Min, Max [3]float64
+	Offset   int32 // first primitive of a leaf, or the second child of an interior node
+	Count    int32 // primitives in a leaf, 0 for interior nodes
+	Axis     uint8 // axis the children were split along
+}
+
+// NewLinearBVHFromList builds like NewBVHNodeFromList and flattens the result
+// whatever opts.Flat says.
+func NewLinearBVHFromList(list *HittableList, opts BVHOptions) *Hittable {
+	opts.Flat = true
+	return NewBVH(list.Objects, opts)
+}
+
+// FlattenBVH copies a tree from NewBVH into a LinearBVH. A median-split node
+// whose children are both primitives becomes one leaf holding them.
+func FlattenBVH(root *Hittable) *LinearBVH {
+	b := &LinearBVH{BBOXField: (*root).BBOX()}
+	b.flatten(*root)
+	return b
+}
+
+func (b *LinearBVH) flatten(h Hittable) int32 {
+	index := int32(len(b.Nodes))
+	b.Nodes = append(b.Nodes, LinearBVHNode{})
+	node := LinearBVHNode{}
+	bbox := h.BBOX()
+	for axis := range 3 {
+		node.Min[axis] = bbox.AxisInterval(axis).Min
+		node.Max[axis] = bbox.AxisInterval(axis).Max
+	}
+
+	switch n := h.(type) {
+	case *BVHLeaf:
+		node.Offset, node.Count = b.addPrimitives(n.Objects...)
+	case *BVHNode:
+		switch {
+		case *n.Left == *n.Right:
+			node.Offset, node.Count = b.addPrimitives(n.Left)
+		case !isBVH(*n.Left) && !isBVH(*n.Right):
+			node.Offset, node.Count = b.addPrimitives(n.Left, n.Right)
+		default:
+			first, second := *n.Left, *n.Right
+			c0, c1 := first.BBOX().Centroid(), second.BBOX().Centroid()
+			d := c1.Sub(c0)
+			axis := ArgMax(math.Abs(d.X), math.Abs(d.Y), math.Abs(d.Z))
+			if d.GetDim(axis) < 0 {
+				// keep the lower child first so the ray direction picks the near one
+				first, second = second, first
+			}
+			node.Axis = uint8(axis)
+			b.flatten(first)
+			node.Offset = b.flatten(second)
+		}
+	default:
+		// the whole tree is a single primitive
+		node.Offset, node.Count = b.addPrimitives(&h)
+	}
+	b.Nodes[index] = node
+	return index
+}
+
+func (b *LinearBVH) addPrimitives(objects ...*Hittable) (offset, count int32) {
+	offset = int32(len(b.Primitives))
+	for _, obj := range objects {
+		b.Primitives = append(b.Primitives, *obj)
+	}
+	return offset, int32(len(objects))
+}
+
+// hit is the slab test against precomputed reciprocal directions.
+func (n *LinearBVHNode) hit(origin, invDir *[3]float64, tMin, tMax float64) bool {
+	for axis := range 3 {
+		t0 := (n.Min[axis] - origin[axis]) * invDir[axis]
+		t1 := (n.Max[axis] - origin[axis]) * invDir[axis]
+		if t0 > t1 {
+			t0, t1 = t1, t0
+		}
+		tMin, tMax = max(t0, tMin), min(t1, tMax)
+		if tMax <= tMin {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *LinearBVH) Hit(r Ray, i *Interval, rec *HitRecord, rng *rand.Rand) bool {
+	origin := [3]float64{r.Origin.X, r.Origin.Y, r.Origin.Z}
+	invDir := [3]float64{1 / r.Direction.X, 1 / r.Direction.Y, 1 / r.Direction.Z}
+	dirIsNeg := [3]bool{invDir[0] < 0, invDir[1] < 0, invDir[2] < 0}
+
+	stack := make([]int32, 0, 64)
+	current := int32(0)
+	hitAnything := false
+	closestSoFar := i.Max
+	for {
+		node := &b.Nodes[current]
+		if node.hit(&origin, &invDir, i.Min, closestSoFar) {
+			if node.Count > 0 {
+				for _, obj := range b.Primitives[node.Offset : node.Offset+node.Count] {
+					if obj.Hit(r, NewInterval(i.Min, closestSoFar), rec, rng) {
+						hitAnything = true
+						closestSoFar = rec.T
+					}
+				}
+			} else if dirIsNeg[node.Axis] {
+				// the second child lies nearer along the ray, visit it first
+				stack = append(stack, current+1)
+				current = node.Offset
+				continue
+			} else {
+				stack = append(stack, node.Offset)
+				current++
+				continue
+			}
+		}
+		if len(stack) == 0 {
+			break
+		}
+		current = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+	}
+	return hitAnything
+}
+func (b *LinearBVH) BBOX() *AABB {
+	return b.BBOXField
+}
@@ -1,17 +1,218 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"math"
 	"math/rand/v2"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
-	cam := NewCamera()
-	cam.CamConfig10()
-	cam.Render(World10())
+	opts, err := ParseArgs(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		if !errors.Is(err, ErrReported) {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		}
+		os.Exit(2)
+	}
+	if err := run(opts); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(opts *Options) error {
+	if opts.From != "" {
+		fb, err := ReadHDRImage(opts.From)
+		if err != nil {
+			return err
+		}
+		writer, err := opts.ImageWriter()
+		if err != nil {
+			return err
+		}
+		return WriteImageFile(opts.Output, fb, writer)
+	}
+	start := time.Now()
+	cam, world, err := opts.Load()
+	if err != nil {
+		return err
+	}
+	if opts.BVHStats {
+		fmt.Printf("Built world in %v\n", time.Since(start).Round(time.Millisecond))
+		roots, paths := FindBVHs(world)
+		for i, root := range roots {
+			fmt.Printf("BVH %s: %v\n", paths[i], ComputeBVHStats(root))
+		}
+	}
+	writer, err := opts.ImageWriter()
+	if err != nil {
+		return err
+	}
+
+	var resumed *Accumulator
+	if opts.Resume {
+		checkpoint, err := LoadCheckpoint(opts.Checkpoint)
+		if err != nil {
+			return err
+		}
+		if resumed, err = checkpoint.Resume(&cam, world); err != nil {
+			return fmt.Errorf("%s: %w", opts.Checkpoint, err)
+		}
+		fmt.Printf("Resuming from %d samples per pixel.\n", slices.Min(resumed.Samples))
+	}
+
+	// an interrupt, or the SIGTERM of a scheduler preempting the job, finishes
+	// the tiles in flight and saves what has been rendered
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// the passes a checkpoint alone splits the render into don't rewrite the output
+	snapshots := opts.PassSamples > 0 || opts.Adaptive > 0
+	lastSnapshot, lastCheckpoint := time.Now(), time.Now()
+	afterPass := func(accumulator *Accumulator, samples int) error {
+		if snapshots && time.Since(lastSnapshot) >= opts.Snapshot {
+			lastSnapshot = time.Now()
+			if err := WriteImageFile(opts.Output, accumulator.Framebuffer(), writer); err != nil {
+				return err
+			}
+		}
+		if opts.Checkpoint != "" && time.Since(lastCheckpoint) >= opts.CheckpointEvery {
+			lastCheckpoint = time.Now()
+			return NewCheckpoint(&cam, world, accumulator).Save(opts.Checkpoint)
+		}
+		return nil
+	}
+	accumulator, err := cam.RenderProgressive(ctx, world, resumed, afterPass)
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "Interrupted, saving the image so far.")
+	}
+	// kept after a finished render too, so it can be resumed with more samples
+	if opts.Checkpoint != "" {
+		if err := NewCheckpoint(&cam, world, accumulator).Save(opts.Checkpoint); err != nil {
+			return err
+		}
+	}
+	if opts.Heatmap != "" {
+		heatmapWriter, err := ImageWriterForPath(opts.Heatmap)
+		if err != nil {
+			return err
+		}
+		heatmapWriter = WithDisplay(heatmapWriter, LegacyDisplay)
+		if err := WriteImageFile(opts.Heatmap, accumulator.Heatmap(), heatmapWriter); err != nil {
+			return err
+		}
+	}
+	fb := accumulator.Framebuffer()
+	if opts.Denoise {
+		if opts.Raw != "" {
+			rawWriter, err := ImageWriterForPath(opts.Raw)
+			if err != nil {
+				return err
+			}
+			if err := WriteImageFile(opts.Raw, fb, WithDisplay(rawWriter, opts.Display)); err != nil {
+				return err
+			}
+		}
+		opts.DenoiseOptions.Workers = cam.Workers
+		if fb, err = Denoise(accumulator, opts.DenoiseOptions); err != nil {
+			return err
+		}
+	}
+	return writeOutputs(opts, fb, accumulator.AOVs, writer)
 }
 
-func World1() *HittableList { // 3 spheres
+// writeOutputs saves the beauty image and the requested AOVs, as extra layers
+// when the output is an EXR and otherwise as files like out.albedo.png.
+func writeOutputs(opts *Options, fb *Framebuffer, aovs *AOVBuffers, writer ImageWriter) error {
+	if len(opts.AOVs) == 0 {
+		return WriteImageFile(opts.Output, fb, writer)
+	}
+	if exr, ok := writer.(EXRWriter); ok {
+		channels := []EXRChannel{{Name: "R"}, {Name: "G"}, {Name: "B"}}
+		for k := range channels {
+			channels[k].Values = fb.Channel(k)
+		}
+		for _, aov := range opts.AOVs {
+			layer := aovs.Framebuffer(aov, false)
+			for k, name := range aov.Channels() {
+				channels = append(channels, EXRChannel{Name: name, Values: layer.Channel(k)})
+			}
+		}
+		return WriteFileAtomic(opts.Output, func(w io.Writer) error {
+			return WriteEXR(w, fb.Width, fb.Height, channels, exr.Compression)
+		})
+	}
+
+	if err := WriteImageFile(opts.Output, fb, writer); err != nil {
+		return err
+	}
+	ext := filepath.Ext(opts.Output)
+	base := strings.TrimSuffix(opts.Output, ext)
+	for _, aov := range opts.AOVs {
+		display := !IsHDRWriter(writer)
+		aovWriter := writer
+		if display {
+			// albedo is a color, the others are remapped to [0, 1] already
+			transform := DisplayTransform{Encoding: EncodeLinear}
+			if aov == AOVAlbedo {
+				transform = DefaultDisplay
+			}
+			aovWriter = WithDisplay(writer, transform)
+		}
+		path := base + "." + aov.String() + ext
+		if err := WriteImageFile(path, aovs.Framebuffer(aov, display), aovWriter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type BuiltinScene struct {
+	Name        string
+	Description string
+	World       func(rng *rand.Rand, bvh BVHOptions) *HittableList
+	Configure   func(*Camera)
+}
+
+var BuiltinScenes = []BuiltinScene{
+	{"spheres", "three spheres on a ground plane", World1, (*Camera).CamConfig1},
+	{"red-blue", "red & blue spheres", World2, (*Camera).CamConfig2},
+	{"bouncing", "bouncing spheres", World3, (*Camera).CamConfig3},
+	{"checkered", "checkered spheres", World4, (*Camera).CamConfig4},
+	{"earth", "textured globe", World5, (*Camera).CamConfig5},
+	{"marble", "perlin noise marble", World6, (*Camera).CamConfig6},
+	{"quads", "quads", World7, (*Camera).CamConfig7},
+	{"lit-marble", "purple marble lit by two sphere lights", World8, (*Camera).CamConfig8},
+	{"cornell", "foggy cornell box", World9, (*Camera).CamConfig9},
+	{"final", "in a weekend final output", World10, (*Camera).CamConfig10},
+}
+
+func FindBuiltinScene(name string) *BuiltinScene {
+	for i := range BuiltinScenes {
+		if BuiltinScenes[i].Name == name {
+			return &BuiltinScenes[i]
+		}
+	}
+	return nil
+}
+
+func World1(rng *rand.Rand, bvh BVHOptions) *HittableList { // 3 spheres
 	materialGround := NewLambertian(NewVec3(0.8, 0.8, 0))
 	materialCenter := NewLambertian(NewVec3(0.1, 0.2, 0.5))
 	materialLeft := NewDielectric(1.50)
@@ -26,7 +227,7 @@ func World1() *HittableList { // 3 spheres
 
 	return NewHittableList(s1, s2, s3, s4, s5)
 }
-func World2() *HittableList { // red & blue spheres
+func World2(rng *rand.Rand, bvh BVHOptions) *HittableList { // red & blue spheres
 	R := math.Cos(math.Pi / 4)
 	materialLeft := NewLambertian(NewVec3(0, 0, 1))
 	materialRight := NewLambertian(NewVec3(1, 0, 0))
@@ -37,7 +238,7 @@ func World2() *HittableList { // red & blue spheres
 	return NewHittableList(s1, s2)
 }
 
-func World3() *HittableList { // bouncing spheres
+func World3(rng *rand.Rand, bvh BVHOptions) *HittableList { // bouncing spheres
 	groundMaterial := NewLambertianFromTexture(NewCheckeredTexture(0.32, NewVec3(0.2, 0.3, 0.1), NewVec3(0.9, 0.9, 0.9)))
 	s1 := NewSphere(NewVec3(0, -1000, -0), 1000, groundMaterial)
 
@@ -45,19 +246,19 @@ func World3() *HittableList { // bouncing spheres
 
 	for i := -11; i < 11; i++ {
 		for j := -11; j < 11; j++ {
-			chooseMat := rand.Float64()
-			center := NewVec3(float64(i)+0.9*rand.Float64(), 0.2, float64(j)+0.9*rand.Float64())
+			chooseMat := rng.Float64()
+			center := NewVec3(float64(i)+0.9*rng.Float64(), 0.2, float64(j)+0.9*rng.Float64())
 
 			if center.Sub(NewVec3(4, 0.2, 0)).Length() > 0.9 {
 				if chooseMat < 0.8 {
-					albedo := NewVec3(rand.Float64(), rand.Float64(), rand.Float64()).Mul(NewVec3(rand.Float64(), rand.Float64(), rand.Float64()))
-					center2 := center.Add(NewVec3(0, RandFloatInRange(0, 0.5), 0))
+					albedo := NewVec3(rng.Float64(), rng.Float64(), rng.Float64()).Mul(NewVec3(rng.Float64(), rng.Float64(), rng.Float64()))
+					center2 := center.Add(NewVec3(0, RandFloatInRange(rng, 0, 0.5), 0))
 					sM := NewLambertian(albedo)
 					sN := NewMovingSphere(center, center2, 0.2, sM)
 					world.Add(sN)
 				} else if chooseMat < 0.95 {
-					albedo := NewBoundedRandomVec(0.5, 1)
-					fuzz := RandFloatInRange(0, 0.5)
+					albedo := NewBoundedRandomVec(rng, 0.5, 1)
+					fuzz := RandFloatInRange(rng, 0, 0.5)
 					sM := NewMetal(albedo, fuzz)
 					sN := NewSphere(center, 0.2, sM)
 					world.Add(sN)
@@ -81,10 +282,10 @@ func World3() *HittableList { // bouncing spheres
 	mat3 := NewMetal(NewVec3(0.7, 0.6, 0.5), 0)
 	s4 := NewSphere(NewVec3(4, 1, 0), 1, mat3)
 	world.Add(s4)
-	return NewHittableList(NewBVHNodeFromList(world))
+	return NewHittableList(NewBVHNodeFromList(world, bvh))
 }
 
-func World4() *HittableList { // checkered spheres
+func World4(rng *rand.Rand, bvh BVHOptions) *HittableList { // checkered spheres
 	groundMaterial := NewLambertianFromTexture(NewCheckeredTexture(0.32, NewVec3(0.2, 0.3, 0.1), NewVec3(0.9, 0.9, 0.9)))
 	s1 := NewSphere(NewVec3(0, -10, 0), 10, groundMaterial)
 	s2 := NewSphere(NewVec3(0, 10, 0), 10, groundMaterial)
@@ -92,21 +293,21 @@ func World4() *HittableList { // checkered spheres
 	return NewHittableList(s1, s2)
 }
 
-func World5() *HittableList { // texture
+func World5(rng *rand.Rand, bvh BVHOptions) *HittableList { // texture
 	earthSurface := NewLambertianFromTexture(NewImageTexture("../textures/earthmap.jpg"))
 	globe := NewSphere(NewVec3(0, 0, 0), 2, earthSurface)
 	return NewHittableList(globe)
 }
 
-func World6() *HittableList { // perlin noise marble
-	perlinMaterial := NewLambertianFromTexture(NewNoiseTexture(4))
+func World6(rng *rand.Rand, bvh BVHOptions) *HittableList { // perlin noise marble
+	perlinMaterial := NewLambertianFromTexture(NewNoiseTexture(rng, 4))
 	s1 := NewSphere(NewVec3(0, -1000, 0), 1000, perlinMaterial)
 	s2 := NewSphere(NewVec3(0, 2, 0), 2, perlinMaterial)
 
 	return NewHittableList(s1, s2)
 }
 
-func World7() *HittableList { // quads
+func World7(rng *rand.Rand, bvh BVHOptions) *HittableList { // quads
 	leftRed := NewLambertian(NewVec3(1.0, 0.2, 0.2))
 	backGreen := NewLambertian(NewVec3(0.2, 1.0, 0.2))
 	rightBlue := NewLambertian(NewVec3(0.2, 0.2, 1.0))
@@ -122,9 +323,9 @@ func World7() *HittableList { // quads
 	return NewHittableList(q1, q2, q3, q4, q5)
 }
 
-func World8() *HittableList { // purple marble
+func World8(rng *rand.Rand, bvh BVHOptions) *HittableList { // purple marble
 
-	perlinMaterial := NewLambertianFromTexture(NewNoiseTexture(3))
+	perlinMaterial := NewLambertianFromTexture(NewNoiseTexture(rng, 3))
 	diffuseLightRed := NewColoredDiffuseLight(NewVec3(0, 0, 255))
 	diffuseLightBlue := NewColoredDiffuseLight(NewVec3(255, 0, 0))
 
@@ -137,7 +338,7 @@ func World8() *HittableList { // purple marble
 	return NewHittableList(s1, s2, s3, s4, s5)
 }
 
-func World9() *HittableList { // cornell box
+func World9(rng *rand.Rand, bvh BVHOptions) *HittableList { // cornell box
 	red := NewLambertian(NewVec3(0.65, 0.05, 0.05))
 	white := NewLambertian(NewVec3(0.73, 0.73, 0.73))
 	green := NewLambertian(NewVec3(0.12, 0.45, 0.15))
@@ -146,8 +347,8 @@ func World9() *HittableList { // cornell box
 	world := NewHittableList()
 	world.Add(NewCornellBox(green, white, red, light))
 
-	box1 := NewTranslateY(NewRotateY(NewBox(NewVec3(0, 0, 0), NewVec3(165, 330, 165), white), 15), NewVec3(265, 0, 295))
-	box2 := NewTranslateY(NewRotateY(NewBox(NewVec3(0, 0, 0), NewVec3(165, 165, 165), white), -18), NewVec3(130, 0, 65))
+	box1 := turnAndMove(NewBox(NewVec3(0, 0, 0), NewVec3(165, 330, 165), white), 15, NewVec3(265, 0, 295))
+	box2 := turnAndMove(NewBox(NewVec3(0, 0, 0), NewVec3(165, 165, 165), white), -18, NewVec3(130, 0, 65))
 
 	cm1 := NewConstantMediumFromColor(box1, 0.01, NewVec3(0, 0, 0))
 	world.Add(cm1)
@@ -157,7 +358,7 @@ func World9() *HittableList { // cornell box
 	return world
 }
 
-func World10() *HittableList { // in a weekend final output
+func World10(rng *rand.Rand, bvh BVHOptions) *HittableList { // in a weekend final output
 	var boxes1 HittableList
 
 	ground := NewLambertian(NewVec3(0.48, 0.83, 0.53))
@@ -166,12 +367,12 @@ func World10() *HittableList { // in a weekend final output
 		for j := range boxesPerSide {
 			w := 100.0
 			x0, y0, z0 := -1000.0+float64(i)*w, 0.0, -1000.0+float64(j)*w
-			x1, y1, z1 := x0+w, RandFloatInRange(1, 101), z0+w
+			x1, y1, z1 := x0+w, RandFloatInRange(rng, 1, 101), z0+w
 
 			boxes1.Add(NewBox(NewVec3(x0, y0, z0), NewVec3(x1, y1, z1), ground))
 		}
 	}
-	world := NewHittableList(NewBVHNodeFromList(&boxes1))
+	world := NewHittableList(NewBVHNodeFromList(&boxes1, bvh))
 	light := NewColoredDiffuseLight(NewVec3(7, 7, 7))
 	q1 := NewQuad(NewVec3(123, 554, 147), NewVec3(300, 0, 0), NewVec3(0, 0, 265), light)
 	world.Add(q1)
@@ -204,7 +405,7 @@ func World10() *HittableList { // in a weekend final output
 	s4 := NewSphere(NewVec3(400, 200, 400), 100, earthSurface)
 	world.Add(s4)
 
-	perlinMaterial := NewLambertianFromTexture(NewNoiseTexture(0.2))
+	perlinMaterial := NewLambertianFromTexture(NewNoiseTexture(rng, 0.2))
 	s5 := NewSphere(NewVec3(220, 280, 300), 80, perlinMaterial)
 	world.Add(s5)
 
@@ -212,14 +413,25 @@ func World10() *HittableList { // in a weekend final output
 
 	white := NewLambertian(NewVec3(0.73, 0.73, 0.73))
 	for range 1000 {
-		s := NewSphere(NewBoundedRandomVec(0, 165), 10, white)
+		s := NewSphere(NewBoundedRandomVec(rng, 0, 165), 10, white)
 		boxes2.Add(s)
 	}
 
-	world.Add(NewTranslateY(NewRotateY(NewBVHNodeFromList(&boxes2), 15), NewVec3(-100, 270, 395)))
+	world.Add(turnAndMove(NewBVHNodeFromList(&boxes2, bvh), 15, NewVec3(-100, 270, 395)))
 	return world
 }
 
+// turnAndMove rotates object by angle degrees about the y axis, then moves it
+// by offset. The built-in scenes only pass constants, which can't fail.
+func turnAndMove(object *Hittable, angle float64, offset Vec3) *Hittable {
+	m, _ := RotationMat4(NewVec3(0, 1, 0), angle)
+	h, err := transformed(object, TranslationMat4(offset).Mul(m))
+	if err != nil {
+		panic(fmt.Sprintf("built-in scene: %v", err))
+	}
+	return h
+}
+
 func (c *Camera) CamConfig1() {
 	c.AspectRatio = 16.0 / 9.0
 	c.ImageWidth = 400
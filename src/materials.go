@@ -6,8 +6,15 @@ import (
 )
 
 type Material interface {
-	Scatter(rIn Ray, rec *HitRecord, attenuation *Vec3, scattered *Ray) bool
+	Scatter(rIn Ray, rec *HitRecord, attenuation *Vec3, scattered *Ray, rng *rand.Rand) bool
+	// ScatteringPDF is the solid-angle density Scatter samples scattered with;
+	// zero for specular materials, which are skipped by light sampling.
+	ScatteringPDF(rIn Ray, rec *HitRecord, scattered Ray) float64
+	// Eval is the BSDF times the cosine term for a given scattered direction.
+	Eval(rIn Ray, rec *HitRecord, scattered Ray) Vec3
 	Emitted(u, v float64, p Vec3) Vec3
+	// AlbedoAt is the surface color at a hit, for feature buffers.
+	AlbedoAt(rec *HitRecord) Vec3
 }
 
 type NoEmittable struct{} // any struct with this type will promote this method to be called eg. lambertian.emitted
@@ -15,12 +22,22 @@ func (ne *NoEmittable) Emitted(u, v float64, p Vec3) Vec3 {
 	return NewVec3(0, 0, 0)
 }
 
-type NoScatter struct{}
+type NoScatter struct {
+	NoScatteringPDF
+}
 
-func (ns *NoScatter) Scatter(rIn Ray, rec *HitRecord, attenuation *Vec3, scattered *Ray) bool {
+func (ns *NoScatter) Scatter(rIn Ray, rec *HitRecord, attenuation *Vec3, scattered *Ray, rng *rand.Rand) bool {
 	return false
 }
 
+type NoScatteringPDF struct{} // for specular materials and lights
+func (np *NoScatteringPDF) ScatteringPDF(rIn Ray, rec *HitRecord, scattered Ray) float64 {
+	return 0
+}
+func (np *NoScatteringPDF) Eval(rIn Ray, rec *HitRecord, scattered Ray) Vec3 {
+	return NewVec3(0, 0, 0)
+}
+
 type Lambertian struct {
 	Tex *Texture
 	NoEmittable
@@ -34,8 +51,8 @@ func NewLambertianFromTexture(t *Texture) *Material {
 	m := Material(&Lambertian{Tex: t})
 	return &m
 }
-func (l *Lambertian) Scatter(rIn Ray, rec *HitRecord, attenuation *Vec3, scattered *Ray) bool {
-	scatterDirection := rec.Normal.Add(RandomUnitVector())
+func (l *Lambertian) Scatter(rIn Ray, rec *HitRecord, attenuation *Vec3, scattered *Ray, rng *rand.Rand) bool {
+	scatterDirection := rec.Normal.Add(RandomUnitVector(rng))
 	if scatterDirection.NearZero() {
 		scatterDirection = rec.Normal
 	}
@@ -43,28 +60,125 @@ func (l *Lambertian) Scatter(rIn Ray, rec *HitRecord, attenuation *Vec3, scatter
 	*attenuation = (*l.Tex).Value(rec.U, rec.V, rec.P)
 	return true
 }
+func (l *Lambertian) ScatteringPDF(rIn Ray, rec *HitRecord, scattered Ray) float64 {
+	direction := scattered.Direction.GetUnitVec()
+	return max(0, Dot(&direction, &rec.Normal)) / math.Pi
+}
+func (l *Lambertian) AlbedoAt(rec *HitRecord) Vec3 {
+	return (*l.Tex).Value(rec.U, rec.V, rec.P)
+}
+func (l *Lambertian) Eval(rIn Ray, rec *HitRecord, scattered Ray) Vec3 {
+	return (*l.Tex).Value(rec.U, rec.V, rec.P).Scale(l.ScatteringPDF(rIn, rec, scattered))
+}
+
+// OrenNayar is a rough diffuse surface of V-shaped facets whose slopes have a
+// standard deviation of Sigma radians, read from its texture's red channel.
+// Unlike Lambertian it flattens towards the light and brightens at grazing
+// angles, like clay or cloth. It samples the same way, and with Sigma zero it
+// renders exactly as Lambertian does.
+type OrenNayar struct {
+	Tex   *Texture
+	Sigma *Texture
+	NoEmittable
+}
+
+func NewOrenNayar(albedo Vec3, sigma float64) *Material {
+	return NewOrenNayarFromTextures(NewSolidColor(albedo), scalarTexture(sigma))
+}
+func NewOrenNayarFromTextures(t, sigma *Texture) *Material {
+	m := Material(&OrenNayar{Tex: t, Sigma: sigma})
+	return &m
+}
+
+// factor scales the Lambertian response for light arriving from wi and
+// leaving along the ray's reverse, following the qualitative model of Oren
+// and Nayar (1994).
+func (o *OrenNayar) factor(rIn Ray, rec *HitRecord, wi Vec3) float64 {
+	sigma := max(0, (*o.Sigma).Value(rec.U, rec.V, rec.P).X)
+	if sigma == 0 {
+		return 1
+	}
+	s2 := sigma * sigma
+	a := 1 - s2/(2*(s2+0.33))
+	b := 0.45 * s2 / (s2 + 0.09)
+
+	wo := rIn.Direction.Negate().GetUnitVec()
+	wi = wi.GetUnitVec()
+	cosI := max(0, min(1, Dot(&wi, &rec.Normal)))
+	cosO := max(0, min(1, Dot(&wo, &rec.Normal)))
+	sinI, sinO := math.Sqrt(1-cosI*cosI), math.Sqrt(1-cosO*cosO)
+	if sinI < 1e-6 || sinO < 1e-6 {
+		return a // the azimuths are undefined and the term vanishes
+	}
+	// cosine of the azimuth between the directions' projections on the surface
+	tangentI := wi.Sub(rec.Normal.Scale(cosI))
+	tangentO := wo.Sub(rec.Normal.Scale(cosO))
+	cosPhi := max(0, Dot(&tangentI, &tangentO)/(sinI*sinO))
+	// sin of the larger angle from the normal times tan of the smaller
+	var sinAlphaTanBeta float64
+	if cosI > cosO {
+		sinAlphaTanBeta = sinO * sinI / cosI
+	} else {
+		sinAlphaTanBeta = sinI * sinO / max(cosO, 1e-6)
+	}
+	return a + b*cosPhi*sinAlphaTanBeta
+}
+
+func (o *OrenNayar) Scatter(rIn Ray, rec *HitRecord, attenuation *Vec3, scattered *Ray, rng *rand.Rand) bool {
+	scatterDirection := rec.Normal.Add(RandomUnitVector(rng))
+	if scatterDirection.NearZero() {
+		scatterDirection = rec.Normal
+	}
+	*scattered = NewRay(rec.P, scatterDirection, rIn.Time)
+	// cosine sampling cancels everything but the albedo and the factor
+	*attenuation = (*o.Tex).Value(rec.U, rec.V, rec.P)
+	if k := o.factor(rIn, rec, scatterDirection); k != 1 {
+		*attenuation = attenuation.Scale(k)
+	}
+	return true
+}
+func (o *OrenNayar) ScatteringPDF(rIn Ray, rec *HitRecord, scattered Ray) float64 {
+	direction := scattered.Direction.GetUnitVec()
+	return max(0, Dot(&direction, &rec.Normal)) / math.Pi
+}
+func (o *OrenNayar) AlbedoAt(rec *HitRecord) Vec3 {
+	return (*o.Tex).Value(rec.U, rec.V, rec.P)
+}
+func (o *OrenNayar) Eval(rIn Ray, rec *HitRecord, scattered Ray) Vec3 {
+	f := (*o.Tex).Value(rec.U, rec.V, rec.P).Scale(o.ScatteringPDF(rIn, rec, scattered))
+	if k := o.factor(rIn, rec, scattered.Direction); k != 1 {
+		f = f.Scale(k)
+	}
+	return f
+}
 
 type Metal struct {
 	Albedo Vec3
 	Fuzz   float64
 	NoEmittable
+	NoScatteringPDF
 }
 
 func NewMetal(albedo Vec3, fuzz float64) *Material {
 	m := Material(&Metal{Albedo: albedo, Fuzz: min(1, fuzz)})
 	return &m
 }
-func (m *Metal) Scatter(rIn Ray, rec *HitRecord, attenuation *Vec3, scattered *Ray) bool {
+func (m *Metal) Scatter(rIn Ray, rec *HitRecord, attenuation *Vec3, scattered *Ray, rng *rand.Rand) bool {
 	reflected := Reflect(&rIn.Direction, &rec.Normal)
-	reflected = reflected.GetUnitVec().Add(RandomUnitVector().Scale(m.Fuzz))
+	reflected = reflected.GetUnitVec().Add(RandomUnitVector(rng).Scale(m.Fuzz))
 	*scattered = NewRay(rec.P, reflected, rIn.Time)
 	*attenuation = m.Albedo
 	return Dot(&scattered.Direction, &rec.Normal) > 0
 }
 
+func (m *Metal) AlbedoAt(rec *HitRecord) Vec3 {
+	return m.Albedo
+}
+
 type Dielectric struct {
 	RefractionIndex float64
 	NoEmittable
+	NoScatteringPDF
 }
 
 func NewDielectric(ri float64) *Material {
@@ -75,7 +189,10 @@ func (d *Dielectric) Reflectance(cosine, refractionIndex float64) float64 {
 	r0 := math.Pow(((1 - refractionIndex) / (1 + refractionIndex)), 2)
 	return r0 + (1-r0)*math.Pow((1-cosine), 5)
 }
-func (d *Dielectric) Scatter(rIn Ray, rec *HitRecord, attenuation *Vec3, scattered *Ray) bool {
+func (d *Dielectric) AlbedoAt(rec *HitRecord) Vec3 {
+	return NewVec3(1, 1, 1)
+}
+func (d *Dielectric) Scatter(rIn Ray, rec *HitRecord, attenuation *Vec3, scattered *Ray, rng *rand.Rand) bool {
 	*attenuation = NewVec3(1, 1, 1)
 	ri := d.RefractionIndex
 	if rec.FrontFace {
@@ -89,7 +206,7 @@ func (d *Dielectric) Scatter(rIn Ray, rec *HitRecord, attenuation *Vec3, scatter
 	cannotRefract := ri*sinTheta > 1.0
 	var direction Vec3
 
-	if cannotRefract || d.Reflectance(cosTheta, ri) > rand.Float64() {
+	if cannotRefract || d.Reflectance(cosTheta, ri) > rng.Float64() {
 		direction = Reflect(&unitDirection, &rec.Normal)
 	} else {
 		direction = Refract(&unitDirection, &rec.Normal, ri)
@@ -116,6 +233,15 @@ func (d *DiffuseLight) Emitted(u, v float64, p Vec3) Vec3 {
 	return (*d.Tex).Value(u, v, p)
 }
 
+// AlbedoAt is the emitted color scaled to fit [0, 1].
+func (d *DiffuseLight) AlbedoAt(rec *HitRecord) Vec3 {
+	emit := d.Emitted(rec.U, rec.V, rec.P)
+	if brightest := max(emit.X, emit.Y, emit.Z); brightest > 1 {
+		return emit.Scale(1 / brightest)
+	}
+	return emit
+}
+
 type Isotropic struct {
 	Tex *Texture
 	NoEmittable
@@ -130,8 +256,17 @@ func NewIsotropicFromTexture(t *Texture) *Material {
 	return &m
 }
 
-func (i Isotropic) Scatter(rIn Ray, rec *HitRecord, attenuation *Vec3, scattered *Ray) bool {
-	*scattered = NewRay(rec.P, RandomUnitVector(), rIn.Time)
+func (i Isotropic) Scatter(rIn Ray, rec *HitRecord, attenuation *Vec3, scattered *Ray, rng *rand.Rand) bool {
+	*scattered = NewRay(rec.P, RandomUnitVector(rng), rIn.Time)
 	*attenuation = (*i.Tex).Value(rec.U, rec.V, rec.P)
 	return true
 }
+func (i Isotropic) AlbedoAt(rec *HitRecord) Vec3 {
+	return (*i.Tex).Value(rec.U, rec.V, rec.P)
+}
+func (i Isotropic) ScatteringPDF(rIn Ray, rec *HitRecord, scattered Ray) float64 {
+	return 1 / (4 * math.Pi)
+}
+func (i Isotropic) Eval(rIn Ray, rec *HitRecord, scattered Ray) Vec3 {
+	return (*i.Tex).Value(rec.U, rec.V, rec.P).Scale(1 / (4 * math.Pi))
+}
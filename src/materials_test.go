@@ -0,0 +1,214 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestOrenNayarZeroSigmaMatchesLambertian(t *testing.T) {
+	albedo := NewVec3(0.7, 0.5, 0.3)
+	oren, lambert := *NewOrenNayar(albedo, 0), *NewLambertian(albedo)
+	sphere := NewSphere(NewVec3(0, 0, 0), 1, NewLambertian(albedo))
+	rng := NewRNG(1, SceneStream)
+	hits := 0
+	for i := range 1000 {
+		origin := RandomUnitVector(rng).Scale(3)
+		target := RandomUnitVector(rng).Scale(0.9)
+		r := NewRay(origin, target.Sub(origin), 0)
+		var rec HitRecord
+		if !(*sphere).Hit(r, NewInterval(0.001, math.Inf(1)), &rec, rng) {
+			continue
+		}
+		hits++
+
+		var orenAttenuation, lambertAttenuation Vec3
+		var orenScattered, lambertScattered Ray
+		orenRNG, lambertRNG := NewRNG(uint64(i), 1), NewRNG(uint64(i), 1)
+		orenOK := oren.Scatter(r, &rec, &orenAttenuation, &orenScattered, orenRNG)
+		lambertOK := lambert.Scatter(r, &rec, &lambertAttenuation, &lambertScattered, lambertRNG)
+		if orenOK != lambertOK || orenAttenuation != lambertAttenuation || orenScattered != lambertScattered {
+			t.Fatalf("ray %d: Scatter gave %v %v %v, Lambertian %v %v %v", i,
+				orenOK, orenAttenuation, orenScattered, lambertOK, lambertAttenuation, lambertScattered)
+		}
+		if orenRNG.Uint64() != lambertRNG.Uint64() {
+			t.Fatalf("ray %d: Scatter drew a different amount of random numbers", i)
+		}
+
+		// a direction neither sampled, possibly below the surface
+		probe := NewRay(rec.P, RandomUnitVector(rng), 0)
+		for _, scattered := range []Ray{orenScattered, probe} {
+			if o, l := oren.ScatteringPDF(r, &rec, scattered), lambert.ScatteringPDF(r, &rec, scattered); o != l {
+				t.Fatalf("ray %d: ScatteringPDF %v, Lambertian %v", i, o, l)
+			}
+			if o, l := oren.Eval(r, &rec, scattered), lambert.Eval(r, &rec, scattered); o != l {
+				t.Fatalf("ray %d: Eval %v, Lambertian %v", i, o, l)
+			}
+		}
+	}
+	if hits == 0 {
+		t.Fatal("no ray hit the sphere")
+	}
+}
+
+// bsdfHit aims a ray at the top of a unit sphere of material m so that it
+// arrives at cosine cosO to the normal.
+func bsdfHit(m *Material, cosO float64) (Ray, HitRecord) {
+	sphere := NewSphere(NewVec3(0, 0, 0), 1, m)
+	wo := NewVec3(math.Sqrt(1-cosO*cosO), 0, cosO)
+	r := NewRay(NewVec3(0, 0, 1).Add(wo.Scale(2)), wo.Negate(), 0)
+	var rec HitRecord
+	(*sphere).Hit(r, NewInterval(0.001, math.Inf(1)), &rec, NewRNG(0, 0))
+	return r, rec
+}
+
+// bsdfEstimate is how a material scatters light arriving from one direction.
+type bsdfEstimate struct {
+	sampled    Vec3    // the mean attenuation of Scatter
+	integrated Vec3    // Eval integrated over the sphere
+	pdf        float64 // ScatteringPDF integrated over the sphere
+}
+
+// estimateBSDF takes strata² samples of Scatter and as many stratified
+// uniform directions on the sphere.
+func estimateBSDF(m *Material, cosO float64, strata int) bsdfEstimate {
+	r, rec := bsdfHit(m, cosO)
+	rng := NewRNG(1, SceneStream)
+	var e bsdfEstimate
+	for i := range strata {
+		for j := range strata {
+			var attenuation Vec3
+			var scattered Ray
+			hit := rec
+			if (*m).Scatter(r, &hit, &attenuation, &scattered, rng) {
+				e.sampled.PlusEq(attenuation)
+			}
+
+			// z and the azimuth are uniform over the sphere's area
+			z := 1 - 2*(float64(i)+rng.Float64())/float64(strata)
+			phi := 2 * math.Pi * (float64(j) + rng.Float64()) / float64(strata)
+			radius := math.Sqrt(max(0, 1-z*z))
+			probe := NewRay(rec.P, rec.Normal.Scale(z).Add(tangentCircle(rec.Normal, phi).Scale(radius)), 0)
+			e.integrated.PlusEq((*m).Eval(r, &rec, probe))
+			e.pdf += (*m).ScatteringPDF(r, &rec, probe)
+		}
+	}
+	n := float64(strata * strata)
+	e.sampled = e.sampled.Scale(1 / n)
+	e.integrated = e.integrated.Scale(4 * math.Pi / n)
+	e.pdf *= 4 * math.Pi / n
+	return e
+}
+
+// tangentCircle is the unit vector at angle phi around normal.
+func tangentCircle(normal Vec3, phi float64) Vec3 {
+	frame := NewONB(normal)
+	return frame.Transform(NewVec3(math.Cos(phi), math.Sin(phi), 0))
+}
+
+func principledWith(p Principled) *Material {
+	if p.BaseColor == nil {
+		p.BaseColor = NewSolidColor(NewVec3(1, 1, 1))
+	}
+	return NewPrincipledMaterial(p)
+}
+
+// checkSamplingMatchesEval compares what Scatter returns on average with
+// Eval integrated over the sphere, for rough enough lobes that stratified
+// directions resolve them, and checks ScatteringPDF is a density.
+func checkSamplingMatchesEval(t *testing.T, name string, m *Material) {
+	t.Helper()
+	for _, cosO := range []float64{1, 0.6, 0.2} {
+		e := estimateBSDF(m, cosO, 300)
+		for _, c := range [][2]float64{{e.sampled.X, e.integrated.X}, {e.sampled.Y, e.integrated.Y}, {e.sampled.Z, e.integrated.Z}} {
+			if math.Abs(c[0]-c[1]) > 0.015 {
+				t.Errorf("%s at cosine %v: Scatter averages %v, Eval integrates to %v", name, cosO, e.sampled, e.integrated)
+				break
+			}
+		}
+		if e.pdf > 1.01 {
+			t.Errorf("%s at cosine %v: ScatteringPDF integrates to %v", name, cosO, e.pdf)
+		}
+	}
+}
+
+func TestPrincipledSamplingMatchesEval(t *testing.T) {
+	grey := NewSolidColor(NewVec3(0.5, 0.5, 0.5))
+	for _, test := range []struct {
+		name string
+		m    *Material
+	}{
+		{"dielectric", principledWith(Principled{BaseColor: NewSolidColor(NewVec3(0.9, 0.5, 0.2)), Roughness: scalarTexture(0.4)})},
+		{"metal", principledWith(Principled{BaseColor: NewSolidColor(NewVec3(0.9, 0.5, 0.2)), Metallic: scalarTexture(1), Roughness: scalarTexture(0.4)})},
+		{"half metal", principledWith(Principled{BaseColor: grey, Metallic: scalarTexture(0.5), Roughness: scalarTexture(0.7)})},
+		{"clear coat", principledWith(Principled{BaseColor: grey, Roughness: scalarTexture(0.6), Clearcoat: scalarTexture(1), ClearcoatRoughness: scalarTexture(0.3)})},
+		{"glass", principledWith(Principled{Roughness: scalarTexture(0.7), Transmission: scalarTexture(1)})},
+		{"coated glass", principledWith(Principled{Roughness: scalarTexture(0.7), Transmission: scalarTexture(0.5), Clearcoat: scalarTexture(1), ClearcoatRoughness: scalarTexture(0.4)})},
+	} {
+		checkSamplingMatchesEval(t, test.name, test.m)
+	}
+}
+
+// TestWhiteFurnace lights white materials evenly from every direction; none
+// may scatter more light than arrives.
+func TestWhiteFurnace(t *testing.T) {
+	white := NewVec3(1, 1, 1)
+	for _, roughness := range []float64{0, 0.5, 1} {
+		r := scalarTexture(roughness)
+		for _, test := range []struct {
+			name string
+			m    *Material
+		}{
+			{"principled", principledWith(Principled{Roughness: r})},
+			{"principled specular 1", principledWith(Principled{Roughness: r, Specular: scalarTexture(1)})},
+			{"principled metal", principledWith(Principled{Roughness: r, Metallic: scalarTexture(1)})},
+			{"principled clear coat", principledWith(Principled{Roughness: r, Clearcoat: scalarTexture(1), ClearcoatRoughness: r})},
+			{"principled glass", principledWith(Principled{Roughness: r, Transmission: scalarTexture(1)})},
+			{"principled coated glass", principledWith(Principled{Roughness: r, Transmission: scalarTexture(0.5), Clearcoat: scalarTexture(1)})},
+			{"conductor", NewRoughConductor(white, roughness)},
+			{"silver", NewConductor(Conductors["Ag"], roughness)},
+			{"dielectric", NewRoughDielectric(1.5, roughness)},
+			{"coated", NewCoatedDiffuse(white, roughness, 1.5)},
+		} {
+			for _, cosO := range []float64{1, 0.6, 0.2, 0.05} {
+				if e := estimateBSDF(test.m, cosO, 100); max(e.sampled.X, e.sampled.Y, e.sampled.Z) > 1.005 {
+					t.Errorf("%s of roughness %v at cosine %v scatters %v", test.name, roughness, cosO, e.sampled)
+				}
+			}
+		}
+	}
+}
+
+func TestMicrofacetSamplingMatchesEval(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		m    *Material
+	}{
+		{"conductor", NewRoughConductor(NewVec3(0.9, 0.6, 0.3), 0.3)},
+		{"rough conductor", NewRoughConductor(NewVec3(0.9, 0.6, 0.3), 0.8)},
+		{"dielectric", NewRoughDielectric(1.5, 0.7)},
+		{"dense dielectric", NewRoughDielectric(2.4, 0.9)},
+		{"gold", NewConductor(Conductors["Au"], 0.4)},
+	} {
+		checkSamplingMatchesEval(t, test.name, test.m)
+	}
+	// light leaving a dielectric sees the index inverted
+	inside := NewRoughDielectric(1.5, 0.7)
+	(*inside).(*RoughDielectric).RefractionIndex = 1 / 1.5
+	checkSamplingMatchesEval(t, "dielectric from inside", inside)
+}
+
+func TestCoatedSamplingMatchesEval(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		m    *Material
+	}{
+		{"polished", NewCoatedDiffuse(NewVec3(0.9, 0.6, 0.3), 0.3, 1.5)},
+		{"rough", NewCoatedDiffuse(NewVec3(0.9, 0.6, 0.3), 0.8, 1.5)},
+		{"tinted", NewCoatedDiffuseMaterial(CoatedDiffuse{
+			Tex: NewSolidColor(NewVec3(0.8, 0.8, 0.8)), Roughness: scalarTexture(0.5), IOR: 1.8,
+			CoatColor: NewVec3(0.9, 0.4, 0.1), Thickness: 0.5,
+		})},
+	} {
+		checkSamplingMatchesEval(t, test.name, test.m)
+	}
+}
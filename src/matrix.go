@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+// Mat4 is a row-major affine transform acting on column vectors.
+type Mat4 [4][4]float64
+
+func IdentityMat4() Mat4 {
+	return Mat4{{1, 0, 0, 0}, {0, 1, 0, 0}, {0, 0, 1, 0}, {0, 0, 0, 1}}
+}
+func TranslationMat4(offset Vec3) Mat4 {
+	m := IdentityMat4()
+	m[0][3], m[1][3], m[2][3] = offset.X, offset.Y, offset.Z
+	return m
+}
+func ScaleMat4(factors Vec3) Mat4 {
+	m := IdentityMat4()
+	m[0][0], m[1][1], m[2][2] = factors.X, factors.Y, factors.Z
+	return m
+}
+
+var ErrZeroAxis = errors.New("rotation axis is zero")
+
+// RotationMat4 rotates counter-clockwise by angle degrees about axis, looking
+// down the axis towards the origin (Rodrigues' formula). The axis has no
+// direction, and so gives no rotation, if it is zero.
+func RotationMat4(axis Vec3, angle float64) (Mat4, error) {
+	if axis.NearZero() {
+		return Mat4{}, ErrZeroAxis
+	}
+	a := axis.GetUnitVec()
+	s, c := math.Sin(DegreesToRadians(angle)), math.Cos(DegreesToRadians(angle))
+	t := 1 - c
+	return Mat4{
+		{t*a.X*a.X + c, t*a.X*a.Y - s*a.Z, t*a.X*a.Z + s*a.Y, 0},
+		{t*a.X*a.Y + s*a.Z, t*a.Y*a.Y + c, t*a.Y*a.Z - s*a.X, 0},
+		{t*a.X*a.Z - s*a.Y, t*a.Y*a.Z + s*a.X, t*a.Z*a.Z + c, 0},
+		{0, 0, 0, 1},
+	}, nil
+}
+
+// Mul returns a*b, which applies b first.
+func (a Mat4) Mul(b Mat4) Mat4 {
+	var m Mat4
+	for i := range 4 {
+		for j := range 4 {
+			for k := range 4 {
+				m[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+	return m
+}
+func (m Mat4) Transpose() Mat4 {
+	var t Mat4
+	for i := range 4 {
+		for j := range 4 {
+			t[i][j] = m[j][i]
+		}
+	}
+	return t
+}
+
+// Inverse uses Gauss-Jordan elimination with partial pivoting; ok is false
+// for singular matrices and ones with an infinite or NaN entry.
+func (m Mat4) Inverse() (inv Mat4, ok bool) {
+	if !m.finite() {
+		return Mat4{}, false
+	}
+	inv = IdentityMat4()
+	for col := range 4 {
+		pivot := col
+		for row := col + 1; row < 4; row++ {
+			if math.Abs(m[row][col]) > math.Abs(m[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(m[pivot][col]) < 1e-12 {
+			return Mat4{}, false
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		inv[col], inv[pivot] = inv[pivot], inv[col]
+
+		scale := 1 / m[col][col]
+		for k := range 4 {
+			m[col][k] *= scale
+			inv[col][k] *= scale
+		}
+		for row := range 4 {
+			if row == col || m[row][col] == 0 {
+				continue
+			}
+			f := m[row][col]
+			for k := range 4 {
+				m[row][k] -= f * m[col][k]
+				inv[row][k] -= f * inv[col][k]
+			}
+		}
+	}
+	return inv, inv.finite()
+}
+
+func (m *Mat4) finite() bool {
+	for i := range 4 {
+		for j := range 4 {
+			if math.IsNaN(m[i][j]) || math.IsInf(m[i][j], 0) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (m *Mat4) Point(p Vec3) Vec3 {
+	return NewVec3(
+		m[0][0]*p.X+m[0][1]*p.Y+m[0][2]*p.Z+m[0][3],
+		m[1][0]*p.X+m[1][1]*p.Y+m[1][2]*p.Z+m[1][3],
+		m[2][0]*p.X+m[2][1]*p.Y+m[2][2]*p.Z+m[2][3],
+	)
+}
+
+// Vector transforms a direction, ignoring translation.
+func (m *Mat4) Vector(v Vec3) Vec3 {
+	return NewVec3(
+		m[0][0]*v.X+m[0][1]*v.Y+m[0][2]*v.Z,
+		m[1][0]*v.X+m[1][1]*v.Y+m[1][2]*v.Z,
+		m[2][0]*v.X+m[2][1]*v.Y+m[2][2]*v.Z,
+	)
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+)
+
+// The microfacet materials work in a local frame around the shading normal,
+// with +Z along it and both directions pointing away from the surface.
+
+// minAlpha is the GGX width below which a surface is treated as a perfect
+// mirror, since the distribution is too sharp to evaluate or light-sample.
+const minAlpha = 1e-3
+
+// GGX is the Trowbridge-Reitz microfacet distribution with width Alpha.
+type GGX struct {
+	Alpha float64
+}
+
+// GGXFromRoughness squares a perceptual roughness in [0, 1] into a width, so
+// roughness steps look even.
+func GGXFromRoughness(roughness float64) GGX {
+	r := max(0, min(1, roughness))
+	return GGX{Alpha: r * r}
+}
+
+func (g GGX) Smooth() bool {
+	return g.Alpha < minAlpha
+}
+
+// D is the density of microfacet normals h, per unit projected area.
+func (g GGX) D(h Vec3) float64 {
+	if h.Z <= 0 {
+		return 0
+	}
+	a2 := g.Alpha * g.Alpha
+	t := h.Z*h.Z*(a2-1) + 1
+	return a2 / (math.Pi * t * t)
+}
+
+// lambda is Smith's auxiliary function for the GGX distribution.
+func (g GGX) lambda(w Vec3) float64 {
+	cos2 := w.Z * w.Z
+	if cos2 == 0 {
+		return math.Inf(1)
+	}
+	tan2 := max(0, 1-cos2) / cos2
+	return (math.Sqrt(1+g.Alpha*g.Alpha*tan2) - 1) / 2
+}
+
+// G1 is the fraction of microfacets visible from w.
+func (g GGX) G1(w Vec3) float64 {
+	return 1 / (1 + g.lambda(w))
+}
+
+// G2 is the height-correlated fraction visible from both wo and wi.
+func (g GGX) G2(wo, wi Vec3) float64 {
+	return 1 / (1 + g.lambda(wo) + g.lambda(wi))
+}
+
+// SampleVisible picks a microfacet normal in proportion to how much of it
+// wo sees (Heitz 2018), so samples are never wasted on back-facing facets.
+func (g GGX) SampleVisible(wo Vec3, rng *rand.Rand) Vec3 {
+	vh := NewVec3(g.Alpha*wo.X, g.Alpha*wo.Y, wo.Z).GetUnitVec()
+	t1 := NewVec3(1, 0, 0)
+	if lensq := vh.X*vh.X + vh.Y*vh.Y; lensq > 0 {
+		t1 = NewVec3(-vh.Y, vh.X, 0).Scale(1 / math.Sqrt(lensq))
+	}
+	t2 := Cross(&vh, &t1)
+	r, phi := math.Sqrt(rng.Float64()), 2*math.Pi*rng.Float64()
+	p1, p2 := r*math.Cos(phi), r*math.Sin(phi)
+	s := 0.5 * (1 + vh.Z)
+	p2 = (1-s)*math.Sqrt(1-p1*p1) + s*p2
+	nh := t1.Scale(p1).Add(t2.Scale(p2)).Add(vh.Scale(math.Sqrt(max(0, 1-p1*p1-p2*p2))))
+	return NewVec3(g.Alpha*nh.X, g.Alpha*nh.Y, max(1e-9, nh.Z)).GetUnitVec()
+}
+
+// VisiblePDF is the density of SampleVisible returning h.
+func (g GGX) VisiblePDF(wo, h Vec3) float64 {
+	return g.G1(wo) * max(0, Dot(&wo, &h)) * g.D(h) / wo.Z
+}
+
+// Local expresses a world direction in the basis.
+func (o ONB) Local(v Vec3) Vec3 {
+	return NewVec3(Dot(&v, &o.U), Dot(&v, &o.V), Dot(&v, &o.W))
+}
+
+// FresnelDielectric is the unpolarised reflectance of light arriving at cosine
+// cosI onto an interface with relative index eta, the far side's over the
+// near side's.
+func FresnelDielectric(cosI, eta float64) float64 {
+	sin2T := (1 - cosI*cosI) / (eta * eta)
+	if sin2T >= 1 {
+		return 1 // total internal reflection
+	}
+	cosT := math.Sqrt(1 - sin2T)
+	rs := (cosI - eta*cosT) / (cosI + eta*cosT)
+	rp := (eta*cosI - cosT) / (eta*cosI + cosT)
+	return (rs*rs + rp*rp) / 2
+}
+
+// FresnelSchlick interpolates from the reflectance f0 at normal incidence to
+// white at grazing angles.
+func FresnelSchlick(f0 Vec3, cosI float64) Vec3 {
+	k := math.Pow(1-max(0, min(1, cosI)), 5)
+	return f0.Add(NewVec3(1, 1, 1).Sub(f0).Scale(k))
+}
+
+// ComplexIOR is a conductor's complex index of refraction, Eta + iK, for the
+// red, green and blue channels.
+type ComplexIOR struct {
+	Eta, K Vec3
+}
+
+// Conductors are measured indices of common metals, from the usual RGB fits
+// of their spectral data at about 650, 550 and 450nm.
+var Conductors = map[string]ComplexIOR{
+	"Au": {NewVec3(0.143119, 0.374957, 1.44248), NewVec3(3.98316, 2.38572, 1.60322)},
+	"Ag": {NewVec3(0.155265, 0.116723, 0.138342), NewVec3(4.82835, 3.12225, 2.14696)},
+	"Cu": {NewVec3(0.200438, 0.924033, 1.10221), NewVec3(3.91295, 2.45285, 2.14219)},
+	"Al": {NewVec3(1.65746, 0.880369, 0.521229), NewVec3(9.22387, 6.26952, 4.837)},
+	"Fe": {NewVec3(2.91140, 2.94970, 2.58450), NewVec3(3.08930, 2.93180, 2.76700)},
+	"Ti": {NewVec3(2.74160, 2.54410, 2.26700), NewVec3(3.81430, 3.43450, 3.03850)},
+	"Cr": {NewVec3(3.10710, 3.18120, 2.32300), NewVec3(3.33140, 3.33190, 3.13710)},
+}
+
+// Fresnel is the unpolarised reflectance of light from air arriving at cosine
+// cosI, per channel.
+func (ior ComplexIOR) Fresnel(cosI float64) Vec3 {
+	return NewVec3(
+		fresnelConductor(cosI, ior.Eta.X, ior.K.X),
+		fresnelConductor(cosI, ior.Eta.Y, ior.K.Y),
+		fresnelConductor(cosI, ior.Eta.Z, ior.K.Z),
+	)
+}
+
+// fresnelConductor is the exact conductor Fresnel equation for one channel.
+func fresnelConductor(cosI, eta, k float64) float64 {
+	cos2 := max(0, min(1, cosI*cosI))
+	sin2 := 1 - cos2
+	t0 := eta*eta - k*k - sin2
+	a2b2 := math.Sqrt(t0*t0 + 4*eta*eta*k*k)
+	a := math.Sqrt(max(0, (a2b2+t0)/2))
+	t1 := a2b2 + cos2
+	t2 := 2 * math.Sqrt(cos2) * a
+	rs := (t1 - t2) / (t1 + t2)
+	t3 := cos2*a2b2 + sin2*sin2
+	t4 := t2 * sin2
+	rp := rs * (t3 - t4) / (t3 + t4)
+	return (rs + rp) / 2
+}
+
+// roughnessAt reads a roughness texture's red channel.
+func roughnessAt(t *Texture, rec *HitRecord) GGX {
+	return GGXFromRoughness((*t).Value(rec.U, rec.V, rec.P).X)
+}
+
+// RoughConductor is a GGX metal. Without an IOR its color is the reflectance
+// at normal incidence, whitening towards grazing angles by Schlick's
+// approximation; with one, the color tints the exact Fresnel reflectance.
+type RoughConductor struct {
+	Tex       *Texture
+	Roughness *Texture    // 0 is a mirror, 1 is very rough
+	IOR       *ComplexIOR // measured optical constants, or nil
+	NoEmittable
+}
+
+func NewRoughConductor(albedo Vec3, roughness float64) *Material {
+	return NewRoughConductorFromTextures(NewSolidColor(albedo), scalarTexture(roughness))
+}
+func NewRoughConductorFromTextures(albedo, roughness *Texture) *Material {
+	m := Material(&RoughConductor{Tex: albedo, Roughness: roughness})
+	return &m
+}
+
+// NewConductor is a metal with a measured index, such as Conductors["Au"].
+func NewConductor(ior ComplexIOR, roughness float64) *Material {
+	return NewConductorFromTextures(ior, NewSolidColor(NewVec3(1, 1, 1)), scalarTexture(roughness))
+}
+func NewConductorFromTextures(ior ComplexIOR, tint, roughness *Texture) *Material {
+	m := Material(&RoughConductor{Tex: tint, Roughness: roughness, IOR: &ior})
+	return &m
+}
+
+// shadingFrame is the local basis at a hit and the outgoing direction in it.
+func shadingFrame(rIn Ray, rec *HitRecord) (ONB, Vec3) {
+	frame := NewONB(rec.Normal)
+	return frame, frame.Local(rIn.Direction.Negate().GetUnitVec())
+}
+
+func (c *RoughConductor) fresnel(rec *HitRecord, cosI float64) Vec3 {
+	color := (*c.Tex).Value(rec.U, rec.V, rec.P)
+	if c.IOR == nil {
+		return FresnelSchlick(color, cosI)
+	}
+	return c.IOR.Fresnel(cosI).Mul(color)
+}
+
+func (c *RoughConductor) Scatter(rIn Ray, rec *HitRecord, attenuation *Vec3, scattered *Ray, rng *rand.Rand) bool {
+	frame, wo := shadingFrame(rIn, rec)
+	if wo.Z <= 0 {
+		return false
+	}
+	ggx := roughnessAt(c.Roughness, rec)
+	if ggx.Smooth() {
+		*scattered = NewRay(rec.P, frame.Transform(NewVec3(-wo.X, -wo.Y, wo.Z)), rIn.Time)
+		*attenuation = c.fresnel(rec, wo.Z)
+		return true
+	}
+	h := ggx.SampleVisible(wo, rng)
+	cosH := Dot(&wo, &h)
+	wi := h.Scale(2 * cosH).Sub(wo)
+	if wi.Z <= 0 {
+		return absorb(rIn, rec, frame, wo, attenuation, scattered) // the facet reflected into the surface
+	}
+	*scattered = NewRay(rec.P, frame.Transform(wi), rIn.Time)
+	*attenuation = c.fresnel(rec, cosH).Scale(ggx.G2(wo, wi) / ggx.G1(wo))
+	return true
+}
+
+// absorb ends a path whose sampled facet sent it to the wrong side of the
+// surface. The hit must still be light-sampled for MIS to add up, and rayColor
+// only does that after a scatter with a positive density, so this reports the
+// mirror direction, which rough surfaces always sample, with no weight.
+func absorb(rIn Ray, rec *HitRecord, frame ONB, wo Vec3, attenuation *Vec3, scattered *Ray) bool {
+	*attenuation = Vec3{}
+	*scattered = NewRay(rec.P, frame.Transform(NewVec3(-wo.X, -wo.Y, wo.Z)), rIn.Time)
+	return true
+}
+
+// halfVector finds the local outgoing and incoming directions of a reflection
+// and the microfacet normal between them, reporting false if either is below
+// the surface.
+func halfVector(rIn Ray, rec *HitRecord, scattered Ray) (wo, wi, h Vec3, ok bool) {
+	frame, wo := shadingFrame(rIn, rec)
+	wi = frame.Local(scattered.Direction.GetUnitVec())
+	if wo.Z <= 0 || wi.Z <= 0 {
+		return wo, wi, h, false
+	}
+	return wo, wi, wo.Add(wi).GetUnitVec(), true
+}
+
+func (c *RoughConductor) ScatteringPDF(rIn Ray, rec *HitRecord, scattered Ray) float64 {
+	ggx := roughnessAt(c.Roughness, rec)
+	wo, _, h, ok := halfVector(rIn, rec, scattered)
+	if ggx.Smooth() || !ok {
+		return 0
+	}
+	return ggx.VisiblePDF(wo, h) / (4 * Dot(&wo, &h))
+}
+
+func (c *RoughConductor) Eval(rIn Ray, rec *HitRecord, scattered Ray) Vec3 {
+	ggx := roughnessAt(c.Roughness, rec)
+	wo, wi, h, ok := halfVector(rIn, rec, scattered)
+	if ggx.Smooth() || !ok {
+		return Vec3{}
+	}
+	return c.fresnel(rec, Dot(&wo, &h)).Scale(ggx.D(h) * ggx.G2(wo, wi) / (4 * wo.Z))
+}
+
+func (c *RoughConductor) AlbedoAt(rec *HitRecord) Vec3 {
+	return c.fresnel(rec, 1)
+}
+
+// RoughDielectric is frosted glass: GGX reflection and refraction after
+// Walter et al. (2007), choosing between them by Fresnel. Like Dielectric it
+// doesn't scale radiance by the squared index ratio on refraction, which
+// cancels out for light that leaves the object again.
+type RoughDielectric struct {
+	RefractionIndex float64
+	Roughness       *Texture
+	NoEmittable
+}
+
+func NewRoughDielectric(ri, roughness float64) *Material {
+	return NewRoughDielectricFromTexture(ri, scalarTexture(roughness))
+}
+func NewRoughDielectricFromTexture(ri float64, roughness *Texture) *Material {
+	m := Material(&RoughDielectric{RefractionIndex: ri, Roughness: roughness})
+	return &m
+}
+
+// eta is the index beyond the surface over the index on the incoming side.
+func (d *RoughDielectric) eta(rec *HitRecord) float64 {
+	if rec.FrontFace {
+		return d.RefractionIndex
+	}
+	return 1 / d.RefractionIndex
+}
+
+// refract bends wo through a facet with normal h, reporting false on total
+// internal reflection.
+func refract(wo, h Vec3, eta float64) (Vec3, bool) {
+	cosI := Dot(&wo, &h)
+	sin2T := (1 - cosI*cosI) / (eta * eta)
+	if sin2T >= 1 {
+		return Vec3{}, false
+	}
+	cosT := math.Sqrt(1 - sin2T)
+	return wo.Negate().Scale(1 / eta).Add(h.Scale(cosI/eta - cosT)), true
+}
+
+func (d *RoughDielectric) Scatter(rIn Ray, rec *HitRecord, attenuation *Vec3, scattered *Ray, rng *rand.Rand) bool {
+	*attenuation = NewVec3(1, 1, 1)
+	frame, wo := shadingFrame(rIn, rec)
+	if wo.Z <= 0 {
+		return false
+	}
+	ggx := roughnessAt(d.Roughness, rec)
+	eta := d.eta(rec)
+	h := NewVec3(0, 0, 1)
+	if !ggx.Smooth() {
+		h = ggx.SampleVisible(wo, rng)
+	}
+	cosH := Dot(&wo, &h)
+	var wi Vec3
+	if rng.Float64() < FresnelDielectric(cosH, eta) {
+		wi = h.Scale(2 * cosH).Sub(wo)
+	} else {
+		wi, _ = refract(wo, h, eta) // the Fresnel term is 1 on total internal reflection
+	}
+	if !ggx.Smooth() {
+		// both branches weigh by the shadowing the sampled facet didn't account for
+		if wi.Z == 0 || (wi.Z > 0) != (Dot(&wi, &h) > 0) {
+			return absorb(rIn, rec, frame, wo, attenuation, scattered)
+		}
+		attenuation.ScaleAssign(ggx.G2(wo, wi) / ggx.G1(wo))
+	}
+	*scattered = NewRay(rec.P, frame.Transform(wi), rIn.Time)
+	return true
+}
+
+// dielectricHalfVector finds the microfacet normal that scatters wo into wi,
+// either by reflection or by refraction, facing the outgoing side.
+func dielectricHalfVector(wo, wi Vec3, eta float64) (Vec3, bool) {
+	var h Vec3
+	if wi.Z > 0 {
+		h = wo.Add(wi)
+	} else {
+		h = wo.Add(wi.Scale(eta)).Negate()
+	}
+	if h.NearZero() {
+		return h, false
+	}
+	h = h.GetUnitVec()
+	if h.Z < 0 {
+		h = h.Negate()
+	}
+	// a facet must face wo, and face wi exactly when wi is on wo's side
+	if Dot(&wo, &h) <= 0 || (Dot(&wi, &h) > 0) != (wi.Z > 0) {
+		return h, false
+	}
+	return h, true
+}
+
+// pdfAndEval gives the density of sampling wi and the BSDF times cosine there.
+func (d *RoughDielectric) pdfAndEval(rIn Ray, rec *HitRecord, scattered Ray) (float64, float64) {
+	ggx := roughnessAt(d.Roughness, rec)
+	frame, wo := shadingFrame(rIn, rec)
+	wi := frame.Local(scattered.Direction.GetUnitVec())
+	if ggx.Smooth() || wo.Z <= 0 || wi.Z == 0 {
+		return 0, 0
+	}
+	eta := d.eta(rec)
+	h, ok := dielectricHalfVector(wo, wi, eta)
+	if !ok {
+		return 0, 0
+	}
+	cosO, cosI := Dot(&wo, &h), Dot(&wi, &h)
+	F := FresnelDielectric(cosO, eta)
+	visible := ggx.VisiblePDF(wo, h)
+	dg := ggx.D(h) * ggx.G2(wo, wi)
+	if wi.Z > 0 {
+		return F * visible / (4 * cosO), F * dg / (4 * wo.Z)
+	}
+	denom := cosO + eta*cosI
+	jacobian := eta * eta * math.Abs(cosI) / (denom * denom)
+	return (1 - F) * visible * jacobian, (1 - F) * dg * cosO * jacobian / wo.Z
+}
+
+func (d *RoughDielectric) ScatteringPDF(rIn Ray, rec *HitRecord, scattered Ray) float64 {
+	pdf, _ := d.pdfAndEval(rIn, rec, scattered)
+	return pdf
+}
+
+func (d *RoughDielectric) Eval(rIn Ray, rec *HitRecord, scattered Ray) Vec3 {
+	_, f := d.pdfAndEval(rIn, rec, scattered)
+	return NewVec3(f, f, f)
+}
+
+func (d *RoughDielectric) AlbedoAt(rec *HitRecord) Vec3 {
+	return NewVec3(1, 1, 1)
+}
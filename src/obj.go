@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// LoadOBJ reads a Wavefront OBJ file and the MTL libraries it references and
+// returns its triangles wrapped in a BVH built with opts.
+func LoadOBJ(path string, opts BVHOptions) (*HittableList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	p := objParser{
+		path:      path,
+		materials: map[string]*Material{},
+		meshes:    map[objMeshKey]*TriangleMesh{},
+		shared:    &TriangleMesh{},
+	}
+	p.materials[""] = NewLambertian(NewVec3(0.73, 0.73, 0.73)) // faces before any usemtl
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		p.line++
+		if err := p.parseLine(scanner.Text()); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var triangles []*Hittable
+	for _, key := range p.order {
+		mesh := p.meshes[key]
+		// every group shares the buffers parsed so far
+		mesh.Vertices, mesh.Normals, mesh.UVs = p.shared.Vertices, p.shared.Normals, p.shared.UVs
+		triangles = append(triangles, mesh.Triangles()...)
+	}
+	if len(triangles) == 0 {
+		return nil, fmt.Errorf("%s: no faces", path)
+	}
+	return NewHittableList(NewBVH(triangles, opts)), nil
+}
+
+type objMeshKey struct {
+	group, material string
+}
+
+type objParser struct {
+	path      string
+	line      int
+	group     string
+	material  string
+	materials map[string]*Material
+	meshes    map[objMeshKey]*TriangleMesh
+	order     []objMeshKey
+	shared    *TriangleMesh
+}
+
+func (p *objParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%s:%d: %s", p.path, p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *objParser) parseLine(line string) error {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	args := fields[1:]
+
+	switch fields[0] {
+	case "v":
+		v, err := p.parseFloats(args, 3, 4)
+		if err != nil {
+			return err
+		}
+		p.shared.Vertices = append(p.shared.Vertices, NewVec3(v[0], v[1], v[2]))
+	case "vt":
+		v, err := p.parseFloats(args, 1, 3)
+		if err != nil {
+			return err
+		}
+		uv := [2]float64{v[0], 0}
+		if len(v) > 1 {
+			uv[1] = v[1]
+		}
+		p.shared.UVs = append(p.shared.UVs, uv)
+	case "vn":
+		v, err := p.parseFloats(args, 3, 3)
+		if err != nil {
+			return err
+		}
+		p.shared.Normals = append(p.shared.Normals, NewVec3(v[0], v[1], v[2]).GetUnitVec())
+	case "f":
+		return p.parseFace(args)
+	case "g", "o":
+		p.group = strings.Join(args, " ")
+	case "usemtl":
+		if len(args) == 0 {
+			return p.errorf("usemtl needs a material name")
+		}
+		name := strings.Join(args, " ")
+		if _, ok := p.materials[name]; !ok {
+			return p.errorf("unknown material %q", name)
+		}
+		p.material = name
+	case "mtllib":
+		if len(args) == 0 {
+			return p.errorf("mtllib needs a file name")
+		}
+		for _, lib := range args {
+			if err := LoadMTL(filepath.Join(filepath.Dir(p.path), lib), p.materials); err != nil {
+				return p.errorf("%v", err)
+			}
+		}
+	}
+	// s, l and other statements don't affect rendering
+	return nil
+}
+
+func (p *objParser) parseFloats(args []string, minCount, maxCount int) ([]float64, error) {
+	if len(args) < minCount || len(args) > maxCount {
+		return nil, p.errorf("expected %d to %d numbers, got %d", minCount, maxCount, len(args))
+	}
+	values := make([]float64, len(args))
+	for i, a := range args {
+		v, err := strconv.ParseFloat(a, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", a)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+func (p *objParser) parseFace(args []string) error {
+	if len(args) < 3 {
+		return p.errorf("face needs at least 3 vertices, got %d", len(args))
+	}
+	corners := make([][3]int, len(args))
+	for i, a := range args {
+		parts := strings.Split(a, "/")
+		if len(parts) > 3 {
+			return p.errorf("invalid face vertex %q", a)
+		}
+		corners[i] = [3]int{-1, -1, -1}
+		counts := [3]int{len(p.shared.Vertices), len(p.shared.UVs), len(p.shared.Normals)}
+		for k, part := range parts {
+			if part == "" {
+				if k == 0 {
+					return p.errorf("face vertex %q has no position index", a)
+				}
+				continue
+			}
+			idx, err := p.resolveIndex(part, counts[k])
+			if err != nil {
+				return err
+			}
+			corners[i][k] = idx
+		}
+	}
+
+	key := objMeshKey{group: p.group, material: p.material}
+	mesh, ok := p.meshes[key]
+	if !ok {
+		mesh = &TriangleMesh{Mat: p.materials[p.material]}
+		p.meshes[key] = mesh
+		p.order = append(p.order, key)
+	}
+	// fan triangulation, fine for the convex polygons exporters produce
+	for i := 1; i+1 < len(corners); i++ {
+		var face MeshFace
+		for k, c := range [3][3]int{corners[0], corners[i], corners[i+1]} {
+			face.V[k], face.VT[k], face.VN[k] = c[0], c[1], c[2]
+		}
+		mesh.Faces = append(mesh.Faces, face)
+	}
+	return nil
+}
+
+// resolveIndex turns a 1-based or negative (relative) OBJ index into a 0-based one.
+func (p *objParser) resolveIndex(s string, count int) (int, error) {
+	idx, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, p.errorf("invalid index %q", s)
+	}
+	switch {
+	case idx > 0 && idx <= count:
+		return idx - 1, nil
+	case idx < 0 && -idx <= count:
+		return count + idx, nil
+	}
+	return 0, p.errorf("index %d out of range (have %d)", idx, count)
+}
+
+type mtlDefinition struct {
+	Name                string
+	Line                int // of its newmtl statement, for errors
+	Kd, Ks, Ke          Vec3
+	Ni, D, Ns           float64
+	Illum               int
+	MapKd               string
+	HasKd, HasKs, HasKe bool
+
+	// the PBR extension's roughness, metallic, sheen-less clear coat and maps
+	Pr, Pm, Pc, Pcr float64
+	MapPr, MapPm    string
+	PBR             bool
+}
+
+// LoadMTL parses a material library into materials, keyed by name.
+func LoadMTL(path string, materials map[string]*Material) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var defs []*mtlDefinition
+	var current *mtlDefinition
+	lineNo := 0
+	errorf := func(format string, args ...any) error {
+		return fmt.Errorf("%s:%d: %s", path, lineNo, fmt.Sprintf(format, args...))
+	}
+	color := func(args []string) (Vec3, error) {
+		if len(args) == 0 || args[0] == "spectral" || args[0] == "xyz" {
+			return Vec3{}, errorf("expected an RGB color")
+		}
+		var rgb [3]float64
+		for i := range 3 {
+			s := args[min(i, len(args)-1)] // a single value means grey
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return Vec3{}, errorf("invalid number %q", s)
+			}
+			rgb[i] = v
+		}
+		return NewVec3(rgb[0], rgb[1], rgb[2]), nil
+	}
+	scalar := func(args []string) (float64, error) {
+		if len(args) != 1 {
+			return 0, errorf("expected a single number")
+		}
+		v, err := strconv.ParseFloat(args[0], 64)
+		if err != nil {
+			return 0, errorf("invalid number %q", args[0])
+		}
+		return v, nil
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		key, args := fields[0], fields[1:]
+		if key == "newmtl" {
+			if len(args) == 0 {
+				return errorf("newmtl needs a name")
+			}
+			current = &mtlDefinition{Name: strings.Join(args, " "), Line: lineNo, Ni: 1.5, D: 1, Pr: 0.5}
+			defs = append(defs, current)
+			continue
+		}
+		if current == nil {
+			return errorf("%s before newmtl", key)
+		}
+		switch key {
+		case "Kd":
+			current.Kd, err = color(args)
+			current.HasKd = true
+		case "Ks":
+			current.Ks, err = color(args)
+			current.HasKs = true
+		case "Ke":
+			current.Ke, err = color(args)
+			current.HasKe = true
+		case "Ni":
+			current.Ni, err = scalar(args)
+		case "Ns":
+			current.Ns, err = scalar(args)
+		case "d":
+			current.D, err = scalar(args)
+		case "Tr":
+			var tr float64
+			tr, err = scalar(args)
+			current.D = 1 - tr
+		case "illum":
+			var v float64
+			v, err = scalar(args)
+			current.Illum = int(v)
+		case "Pr", "Pm", "Pc", "Pcr":
+			var v float64
+			v, err = scalar(args)
+			switch key {
+			case "Pr":
+				current.Pr = v
+			case "Pm":
+				current.Pm = v
+			case "Pc":
+				current.Pc = v
+			case "Pcr":
+				current.Pcr = v
+			}
+			current.PBR = true
+		case "map_Kd", "map_Pr", "map_Pm":
+			name := mapFileName(args)
+			if name == "" {
+				return errorf("%s needs a file name", key)
+			}
+			file := filepath.Join(filepath.Dir(path), name)
+			switch key {
+			case "map_Kd":
+				current.MapKd = file
+			case "map_Pr":
+				current.MapPr = file
+				current.PBR = true
+			case "map_Pm":
+				current.MapPm = file
+				current.PBR = true
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, def := range defs {
+		m, err := def.Material()
+		if err != nil {
+			return fmt.Errorf("%s:%d: material %q: %w", path, def.Line, def.Name, err)
+		}
+		materials[def.Name] = m
+	}
+	return nil
+}
+
+// mapOptionArgs is how many arguments each texture map option takes; -o, -s
+// and -t take up to three numbers.
+var mapOptionArgs = map[string]int{
+	"-blendu": 1, "-blendv": 1, "-boost": 1, "-cc": 1, "-clamp": 1, "-imfchan": 1,
+	"-texres": 1, "-bm": 1, "-type": 1, "-mm": 2, "-o": 3, "-s": 3, "-t": 3,
+}
+
+// mapFileName skips the options before a texture map's file name, which is
+// the rest of the statement and may contain spaces.
+func mapFileName(args []string) string {
+	for len(args) > 0 {
+		n, ok := mapOptionArgs[args[0]]
+		if !ok {
+			break
+		}
+		args = args[1:]
+		for i := 0; i < n && len(args) > 0; i++ {
+			if _, err := strconv.ParseFloat(args[0], 64); err != nil && n == 3 {
+				break // fewer than three numbers
+			}
+			args = args[1:]
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+// Material maps the MTL parameters onto the closest built-in material, or
+// onto Principled when the PBR extension's parameters are given.
+func (def *mtlDefinition) Material() (*Material, error) {
+	if def.HasKe && !def.Ke.NearZero() {
+		return NewColoredDiffuseLight(def.Ke), nil
+	}
+	if def.PBR {
+		return def.principled()
+	}
+	if def.D < 1 || def.Illum == 4 || def.Illum == 6 || def.Illum == 7 {
+		return NewDielectric(def.Ni), nil
+	}
+	if def.HasKs && !def.Ks.NearZero() && (def.Illum == 3 || (def.Kd.NearZero() && def.MapKd == "")) {
+		// Phong exponent to GGX width as in Walter et al., whose square
+		// root is the roughness
+		alpha := math.Sqrt(2 / (def.Ns + 2))
+		return NewRoughConductor(def.Ks, math.Sqrt(alpha)), nil
+	}
+	if def.MapKd != "" {
+		tex, err := LoadImageTexture(def.MapKd)
+		if err != nil {
+			return nil, err
+		}
+		return NewLambertianFromTexture(tex), nil
+	}
+	if !def.HasKd {
+		def.Kd = NewVec3(0.73, 0.73, 0.73)
+	}
+	return NewLambertian(def.Kd), nil
+}
+
+func (def *mtlDefinition) principled() (*Material, error) {
+	p := Principled{
+		Metallic:           scalarTexture(def.Pm),
+		Roughness:          scalarTexture(def.Pr),
+		Clearcoat:          scalarTexture(def.Pc),
+		ClearcoatRoughness: scalarTexture(def.Pcr),
+		Transmission:       scalarTexture(1 - def.D),
+		IOR:                def.Ni,
+	}
+	if def.HasKd {
+		p.BaseColor = NewSolidColor(def.Kd)
+	}
+	for _, m := range []struct {
+		file string
+		dst  **Texture
+		load func(string) (*Texture, error)
+	}{
+		{def.MapKd, &p.BaseColor, LoadImageTexture},
+		{def.MapPr, &p.Roughness, LoadDataTexture},
+		{def.MapPm, &p.Metallic, LoadDataTexture},
+	} {
+		if m.file == "" {
+			continue
+		}
+		tex, err := m.load(m.file)
+		if err != nil {
+			return nil, err
+		}
+		*m.dst = tex
+	}
+	return NewPrincipledMaterial(p), nil
+}
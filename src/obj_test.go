@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles creates files in a fresh directory and returns it.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+const objSquare = "v 0 0 0\nv 1 0 0\nv 1 1 0\nv 0 1 0\n"
+
+func TestLoadOBJ(t *testing.T) {
+	flat := DefaultBVHOptions
+	flat.Flat = true
+	for _, test := range []struct {
+		name      string
+		obj       string
+		mtl       string
+		triangles int
+		err       string // part of the error, if one is expected
+	}{
+		{name: "triangle", obj: objSquare + "f 1 2 3\n", triangles: 1},
+		{name: "quad fan", obj: objSquare + "f 1 2 3 4\n", triangles: 2},
+		{name: "negative indices", obj: objSquare + "f -4 -3 -2\n", triangles: 1},
+		{name: "uvs and normals", obj: objSquare + "vt 0 0\nvt 1 0\nvt 1 1\nvn 0 0 1\nf 1/1/1 2/2/1 3/3/1\nf 1//1 3//1 4//1\n", triangles: 2},
+		{name: "groups and materials", obj: "mtllib a.mtl\n" + objSquare + "g one\nusemtl red\nf 1 2 3\ng two\nusemtl blue\nf 1 3 4\n",
+			mtl: "newmtl red\nKd 1 0 0\nnewmtl blue\nKd 0 0 1\n", triangles: 2},
+		{name: "comments", obj: "# a square\n" + objSquare + "f 1 2 3 # first half\n", triangles: 1},
+		{name: "index out of range", obj: objSquare + "f 1 2 5\n", err: "test.obj:5: index 5 out of range"},
+		{name: "zero index", obj: objSquare + "f 0 1 2\n", err: "index 0 out of range"},
+		{name: "too few vertices", obj: objSquare + "f 1 2\n", err: "test.obj:5"},
+		{name: "bad number", obj: "v 0 0 x\n", err: "test.obj:1"},
+		{name: "unknown material", obj: objSquare + "usemtl missing\nf 1 2 3\n", err: `unknown material "missing"`},
+		{name: "no faces", obj: objSquare, err: "no faces"},
+		{name: "bad material", obj: "mtllib a.mtl\n" + objSquare + "f 1 2 3\n",
+			mtl: "newmtl ok\nKd 1 1 1\n\nnewmtl broken\nmap_Kd missing.png\n", err: `a.mtl:4: material "broken"`},
+		{name: "mtl before newmtl", obj: "mtllib a.mtl\n" + objSquare + "f 1 2 3\n", mtl: "Kd 1 1 1\n", err: "a.mtl:1: Kd before newmtl"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := writeFiles(t, map[string]string{"test.obj": test.obj, "a.mtl": test.mtl})
+			list, err := LoadOBJ(filepath.Join(dir, "test.obj"), flat)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got error %v, want one containing %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len((*list.Objects[0]).(*LinearBVH).Primitives); got != test.triangles {
+				t.Errorf("got %d triangles, want %d", got, test.triangles)
+			}
+		})
+	}
+}
+
+func TestLoadMTL(t *testing.T) {
+	dir := t.TempDir()
+	if err := copyFile("../textures/regs.jpg", filepath.Join(dir, "my texture.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name, mtl string
+		want      string // the material's type
+	}{
+		{"diffuse", "Kd 0.5 0.5 0.5", "*main.Lambertian"},
+		{"no color", "Ns 10", "*main.Lambertian"},
+		{"textured", "map_Kd my texture.jpg", "*main.Lambertian"},
+		{"texture options", "map_Kd -s 1 1 1 -clamp on my texture.jpg", "*main.Lambertian"},
+		{"glass", "Kd 1 1 1\nd 0.5\nNi 1.5", "*main.Dielectric"},
+		{"transparent", "Tr 0.5", "*main.Dielectric"},
+		{"metal", "Ks 0.9 0.8 0.5\nNs 200\nillum 3", "*main.RoughConductor"},
+		{"specular only", "Kd 0 0 0\nKs 0.9 0.9 0.9", "*main.RoughConductor"},
+		{"emitter", "Kd 1 1 1\nKe 4 4 4", "*main.DiffuseLight"},
+		{"black emission", "Kd 1 1 1\nKe 0 0 0", "*main.Lambertian"},
+		{"pbr", "Kd 0.8 0.1 0.1\nPr 0.3\nPm 1", "*main.Principled"},
+		{"pbr map", "map_Pr my texture.jpg", "*main.Principled"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(dir, "test.mtl")
+			if err := os.WriteFile(path, []byte("newmtl m\n"+test.mtl+"\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			materials := map[string]*Material{}
+			if err := LoadMTL(path, materials); err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprintf("%T", *materials["m"]); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMapFileName(t *testing.T) {
+	for _, test := range []struct {
+		args string
+		want string
+	}{
+		{"wood.png", "wood.png"},
+		{"old wood.png", "old wood.png"},
+		{"-s 2 2 1 wood.png", "wood.png"},
+		{"-s 2 wood.png", "wood.png"},
+		{"-clamp on -bm 0.5 old wood.png", "old wood.png"},
+		{"-mm 0 1 -o 0.5 0.5 wood.png", "wood.png"},
+		{"-clamp on", ""},
+	} {
+		if got := mapFileName(strings.Fields(test.args)); got != test.want {
+			t.Errorf("mapFileName(%q) = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+func copyFile(from, to string) error {
+	data, err := os.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(to, data, 0o644)
+}
@@ -11,7 +11,7 @@ type PerlinNoise struct {
 	RandVecs            []Vec3
 }
 
-func NewPerlinNoise() *PerlinNoise {
+func NewPerlinNoise(rng *rand.Rand) *PerlinNoise {
 	count := 256
 	n := PerlinNoise{
 		PointCount: count,
@@ -21,11 +21,11 @@ func NewPerlinNoise() *PerlinNoise {
 		PermZ:      make([]int, count),
 	}
 	for i := range count {
-		n.RandVecs[i] = (NewBoundedRandomVec(-1, 1)).GetUnitVec()
+		n.RandVecs[i] = (NewBoundedRandomVec(rng, -1, 1)).GetUnitVec()
 	}
-	n.GeneratePerm(n.PermX)
-	n.GeneratePerm(n.PermY)
-	n.GeneratePerm(n.PermZ)
+	n.GeneratePerm(rng, n.PermX)
+	n.GeneratePerm(rng, n.PermY)
+	n.GeneratePerm(rng, n.PermZ)
 
 	return &n
 }
@@ -45,16 +45,16 @@ func (n *PerlinNoise) Noise(p Vec3) float64 {
 	return PerlinInterpolation(c, u, v, w)
 }
 
-func (n *PerlinNoise) GeneratePerm(p []int) {
+func (n *PerlinNoise) GeneratePerm(rng *rand.Rand, p []int) {
 	for i := range n.PointCount {
 		p[i] = i
 	}
-	Permute(p, n.PointCount)
+	Permute(rng, p, n.PointCount)
 }
 
-func Permute(p []int, n int) {
+func Permute(rng *rand.Rand, p []int, n int) {
 	for i := n - 1; i > 0; i-- {
-		target := rand.IntN(i + 1)
+		target := rng.IntN(i + 1)
 		p[i], p[target] = p[target], p[i]
 	}
 }
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+)
+
+// Principled is a Disney-style uber material: one set of artist parameters,
+// each a texture read through its red channel except BaseColor, blended into
+// a Burley diffuse lobe, a GGX specular lobe, a rough glass lobe for
+// transmission and a clear coat. Metallic fades the diffuse and glass into a
+// specular lobe tinted by BaseColor; Transmission turns the diffuse into
+// glass. Seen from inside a transmissive object only the glass interface
+// remains.
+//
+// The lobes are layered so the material never reflects more light than it
+// receives: the diffuse only gets what the specular Fresnel lets through, on
+// the way in and out, and everything but the clear coat only what the coat
+// lets through.
+//
+// Every lobe stays glossy, with the roughness floored where GGX can still be
+// sampled, so they can share one sampling density for MIS.
+type Principled struct {
+	BaseColor          *Texture
+	Metallic           *Texture // 0 is a dielectric, 1 a metal
+	Roughness          *Texture
+	Specular           *Texture // dielectric reflectance; 0.5 is 4%, that of most non-metals
+	Clearcoat          *Texture // strength of an extra glossy layer, like car paint
+	ClearcoatRoughness *Texture
+	Transmission       *Texture // 0 is opaque, 1 is glass tinted by BaseColor
+	IOR                float64  // of the glass lobe
+	NoEmittable
+}
+
+// NewPrincipled is an opaque principled material; set the other fields of the
+// result's *Principled to use the rest.
+func NewPrincipled(baseColor Vec3, metallic, roughness float64) *Material {
+	return NewPrincipledMaterial(Principled{
+		BaseColor: NewSolidColor(baseColor),
+		Metallic:  scalarTexture(metallic),
+		Roughness: scalarTexture(roughness),
+	})
+}
+
+// NewPrincipledMaterial fills in any nil parameters with the defaults: grey,
+// non-metallic, half rough, specular 0.5, no clear coat or transmission and an
+// index of 1.5.
+func NewPrincipledMaterial(p Principled) *Material {
+	defaults := []struct {
+		field **Texture
+		value float64
+	}{
+		{&p.BaseColor, 0.8},
+		{&p.Metallic, 0},
+		{&p.Roughness, 0.5},
+		{&p.Specular, 0.5},
+		{&p.Clearcoat, 0},
+		{&p.ClearcoatRoughness, 0.1},
+		{&p.Transmission, 0},
+	}
+	for _, d := range defaults {
+		if *d.field == nil {
+			*d.field = scalarTexture(d.value)
+		}
+	}
+	if p.IOR <= 0 {
+		p.IOR = 1.5
+	}
+	m := Material(&p)
+	return &m
+}
+
+// scalarTexture is a constant for a texture-valued parameter.
+func scalarTexture(v float64) *Texture {
+	return NewSolidColor(NewVec3(v, v, v))
+}
+
+func scalarAt(t *Texture, rec *HitRecord) float64 {
+	return max(0, min(1, (*t).Value(rec.U, rec.V, rec.P).X))
+}
+
+// principledLobes are the parameters at one hit, with the weight of each lobe.
+type principledLobes struct {
+	base                          Vec3
+	f0                            Vec3    // specular reflectance at normal incidence
+	dielectricF0                  float64 // the same, of the non-metallic part
+	roughness                     float64
+	specular, coat                GGX
+	diffuse, glossy, glass, clear float64
+	eta                           float64
+	inside                        bool
+}
+
+func (p *Principled) lobes(rec *HitRecord) principledLobes {
+	metallic := scalarAt(p.Metallic, rec)
+	transmission := scalarAt(p.Transmission, rec)
+	l := principledLobes{
+		base:      (*p.BaseColor).Value(rec.U, rec.V, rec.P),
+		roughness: scalarAt(p.Roughness, rec),
+		eta:       p.IOR,
+		// an opaque surface seen from behind, like the back of a quad, is
+		// shaded as its front against the flipped normal
+		inside: !rec.FrontFace && transmission > 0,
+	}
+	l.specular = GGX{Alpha: max(l.roughness*l.roughness, minAlpha)}
+	coatRoughness := scalarAt(p.ClearcoatRoughness, rec)
+	l.coat = GGX{Alpha: max(coatRoughness*coatRoughness, minAlpha)}
+	if l.inside {
+		l.eta = 1 / p.IOR
+		l.glass = 1
+		return l
+	}
+	l.diffuse = (1 - metallic) * (1 - transmission)
+	l.glass = (1 - metallic) * transmission
+	l.glossy = 1 - l.glass
+	l.clear = scalarAt(p.Clearcoat, rec)
+	l.dielectricF0 = 0.08 * scalarAt(p.Specular, rec)
+	l.f0 = NewVec3(l.dielectricF0, l.dielectricF0, l.dielectricF0).Scale(1 - metallic).Add(l.base.Scale(metallic))
+	return l
+}
+
+// coatF0 is the reflectance of the clear coat, a varnish of index 1.5.
+const coatF0 = 0.04
+
+// schlick is FresnelSchlick for a grey reflectance.
+func schlick(f0, cosI float64) float64 {
+	return f0 + (1-f0)*math.Pow(1-max(0, min(1, cosI)), 5)
+}
+
+// underCoat is the share of light crossing the clear coat at cosine cosI that
+// the coat doesn't reflect.
+func (l *principledLobes) underCoat(cosI float64) float64 {
+	return 1 - l.clear*schlick(coatF0, cosI)
+}
+
+// underSpecular is the share of light crossing the dielectric interface at
+// cosine cosI that reaches the diffuse base.
+func (l *principledLobes) underSpecular(cosI float64) float64 {
+	return 1 - schlick(l.dielectricF0, cosI)
+}
+
+// selection gives the chance of sampling each lobe in proportion to the
+// weight evalAndPDF gives it towards wo.
+func (l *principledLobes) selection(wo Vec3) (diffuse, glossy, glass, clear float64) {
+	through := l.underCoat(wo.Z)
+	diffuse = l.diffuse * Luminance(l.base) * l.underSpecular(wo.Z) * through
+	glossy = l.glossy * Luminance(FresnelSchlick(l.f0, wo.Z)) * through
+	glass = l.glass * through
+	clear = l.clear * schlick(coatF0, wo.Z)
+	total := diffuse + glossy + glass + clear
+	if total <= 0 {
+		return 1, 0, 0, 0
+	}
+	return diffuse / total, glossy / total, glass / total, clear / total
+}
+
+// evalAndPDF gives the BSDF times cosine for scattering wo into wi and the
+// density of Scatter picking wi.
+func (l *principledLobes) evalAndPDF(wo, wi Vec3) (Vec3, float64) {
+	var f Vec3
+	var pdf float64
+	pDiffuse, pGlossy, pGlass, pClear := l.selection(wo)
+	// the layers under the coat lose what it reflects on the way in and,
+	// unless the light refracts into the object, on the way out
+	through := l.underCoat(wo.Z)
+	if wi.Z > 0 {
+		through *= l.underCoat(wi.Z)
+		h := wo.Add(wi).GetUnitVec()
+		cosD := Dot(&wi, &h)
+		if l.diffuse > 0 {
+			// Burley's diffuse, brighter towards grazing on rough surfaces,
+			// lit by what the specular interface lets through
+			fd90 := 0.5 + 2*l.roughness*cosD*cosD
+			retro := (1 + (fd90-1)*math.Pow(1-wi.Z, 5)) * (1 + (fd90-1)*math.Pow(1-wo.Z, 5))
+			transmitted := l.underSpecular(wo.Z) * l.underSpecular(wi.Z)
+			f.PlusEq(l.base.Scale(l.diffuse * retro * transmitted * through * wi.Z / math.Pi))
+			pdf += pDiffuse * wi.Z / math.Pi
+		}
+		reflection := func(g GGX) (float64, float64) {
+			return g.D(h) * g.G2(wo, wi) / (4 * wo.Z), g.VisiblePDF(wo, h) / (4 * cosD)
+		}
+		if l.glossy > 0 {
+			dg, lobePDF := reflection(l.specular)
+			f.PlusEq(FresnelSchlick(l.f0, cosD).Scale(l.glossy * dg * through))
+			pdf += pGlossy * lobePDF
+		}
+		if l.clear > 0 {
+			dg, lobePDF := reflection(l.coat)
+			f.PlusEq(NewVec3(1, 1, 1).Scale(l.clear * schlick(coatF0, cosD) * dg))
+			pdf += pClear * lobePDF
+		}
+	}
+	if l.glass > 0 {
+		if h, ok := dielectricHalfVector(wo, wi, l.eta); ok {
+			cosO, cosI := Dot(&wo, &h), Dot(&wi, &h)
+			F := FresnelDielectric(cosO, l.eta)
+			visible := l.specular.VisiblePDF(wo, h)
+			dg := l.specular.D(h) * l.specular.G2(wo, wi)
+			if wi.Z > 0 {
+				f.PlusEq(NewVec3(1, 1, 1).Scale(l.glass * F * dg * through / (4 * wo.Z)))
+				pdf += pGlass * F * visible / (4 * cosO)
+			} else {
+				denom := cosO + l.eta*cosI
+				jacobian := l.eta * l.eta * math.Abs(cosI) / (denom * denom)
+				tint := l.base
+				if l.inside {
+					tint = NewVec3(1, 1, 1) // tinted once, on the way in
+				}
+				f.PlusEq(tint.Scale(l.glass * (1 - F) * dg * cosO * jacobian * through / wo.Z))
+				pdf += pGlass * (1 - F) * visible * jacobian
+			}
+		}
+	}
+	return f, pdf
+}
+
+func (p *Principled) Scatter(rIn Ray, rec *HitRecord, attenuation *Vec3, scattered *Ray, rng *rand.Rand) bool {
+	frame, wo := shadingFrame(rIn, rec)
+	if wo.Z <= 0 {
+		return false
+	}
+	l := p.lobes(rec)
+	pDiffuse, pGlossy, pGlass, _ := l.selection(wo)
+	var wi Vec3
+	refracted := false
+	switch xi := rng.Float64(); {
+	case xi < pDiffuse:
+		// cosine-weighted, like Lambertian: a uniform point on the sphere above the normal
+		wi = NewVec3(0, 0, 1).Add(RandomUnitVector(rng)).GetUnitVec()
+	case xi < pDiffuse+pGlossy:
+		h := l.specular.SampleVisible(wo, rng)
+		wi = h.Scale(2 * Dot(&wo, &h)).Sub(wo)
+	case xi < pDiffuse+pGlossy+pGlass:
+		h := l.specular.SampleVisible(wo, rng)
+		cosH := Dot(&wo, &h)
+		if rng.Float64() < FresnelDielectric(cosH, l.eta) {
+			wi = h.Scale(2 * cosH).Sub(wo)
+		} else {
+			wi, _ = refract(wo, h, l.eta)
+			refracted = true
+		}
+	default:
+		h := l.coat.SampleVisible(wo, rng)
+		wi = h.Scale(2 * Dot(&wo, &h)).Sub(wo)
+	}
+	f, pdf := l.evalAndPDF(wo, wi)
+	// a facet that sent the light to the other side than the lobe meant to
+	// scatters it nowhere; evalAndPDF would weigh it as the other lobe
+	if pdf <= 0 || wi.Z == 0 || (wi.Z < 0) != refracted {
+		return absorb(rIn, rec, frame, wo, attenuation, scattered)
+	}
+	*scattered = NewRay(rec.P, frame.Transform(wi), rIn.Time)
+	*attenuation = f.Scale(1 / pdf)
+	return true
+}
+
+func (p *Principled) localDirections(rIn Ray, rec *HitRecord, scattered Ray) (principledLobes, Vec3, Vec3, bool) {
+	frame, wo := shadingFrame(rIn, rec)
+	wi := frame.Local(scattered.Direction.GetUnitVec())
+	return p.lobes(rec), wo, wi, wo.Z > 0 && wi.Z != 0
+}
+
+func (p *Principled) ScatteringPDF(rIn Ray, rec *HitRecord, scattered Ray) float64 {
+	l, wo, wi, ok := p.localDirections(rIn, rec, scattered)
+	if !ok {
+		return 0
+	}
+	_, pdf := l.evalAndPDF(wo, wi)
+	return pdf
+}
+
+func (p *Principled) Eval(rIn Ray, rec *HitRecord, scattered Ray) Vec3 {
+	l, wo, wi, ok := p.localDirections(rIn, rec, scattered)
+	if !ok {
+		return Vec3{}
+	}
+	f, _ := l.evalAndPDF(wo, wi)
+	return f
+}
+
+func (p *Principled) AlbedoAt(rec *HitRecord) Vec3 {
+	return (*p.BaseColor).Value(rec.U, rec.V, rec.P)
+}
@@ -0,0 +1,41 @@
+package main
+
+import "math/rand/v2"
+
+// Stream ids for the parts of a render that draw random numbers outside of
+// pixel sampling, so each is reproducible on its own.
+const (
+	SceneStream uint64 = iota + 1
+	BenchRayStream
+	BenchHitStream
+)
+
+func NewRNG(seed, stream uint64) *rand.Rand {
+	return rand.New(rand.NewPCG(seed, SplitMix64(stream)))
+}
+
+// SampleRNG is a reseedable generator that gives every (pixel, sample) pair its
+// own stream, so images don't depend on which worker rendered which pixel.
+type SampleRNG struct {
+	*rand.Rand
+	pcg *rand.PCG
+}
+
+func NewSampleRNG() *SampleRNG {
+	pcg := rand.NewPCG(0, 0)
+	return &SampleRNG{Rand: rand.New(pcg), pcg: pcg}
+}
+
+func (s *SampleRNG) Reset(seed uint64, pixel, sample int) {
+	hi := SplitMix64(seed ^ SplitMix64(uint64(pixel)))
+	s.pcg.Seed(hi, SplitMix64(hi^uint64(sample)))
+}
+
+// SplitMix64 is the finalizer of the splitmix64 generator, used to scramble
+// neighbouring integers into unrelated seeds.
+func SplitMix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
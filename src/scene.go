@@ -0,0 +1,1051 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Scene is everything a scene file describes: the camera settings and the world.
+type Scene struct {
+	Camera Camera
+	World  *HittableList
+}
+
+// LoadScene reads a JSON scene description. Textures, materials and objects can
+// be defined inline or by name in the top-level "textures", "materials" and
+// "objects" maps and referenced by that name anywhere one is expected. Relative
+// file paths resolve against the scene file's directory.
+func LoadScene(path string, seed uint64, bvh BVHOptions) (*Scene, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseScene(data, filepath.Dir(path), seed, bvh)
+}
+
+// ParseScene builds a scene from JSON; seed drives anything random in its
+// construction, such as noise textures, and becomes the camera's seed. Its
+// BVHs and meshes are built with bvh.
+func ParseScene(data []byte, dir string, seed uint64, bvh BVHOptions) (*Scene, error) {
+	var raw json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("scene: %w", err)
+	}
+	root, err := newSceneNode("$", raw)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &sceneLoader{
+		dir:       dir,
+		rng:       NewRNG(seed, SceneStream),
+		bvh:       bvh,
+		textures:  map[string]*Texture{},
+		materials: map[string]*Material{},
+		objects:   map[string]*Hittable{},
+		defs:      map[string]map[string]json.RawMessage{},
+		building:  map[string]bool{},
+	}
+	for _, kind := range []string{"textures", "materials", "objects"} {
+		defs := map[string]json.RawMessage{}
+		if err := root.optional(kind, &defs); err != nil {
+			return nil, err
+		}
+		l.defs[kind] = defs
+	}
+
+	scene := &Scene{Camera: NewCamera(), World: NewHittableList()}
+	scene.Camera.Seed = seed
+	if rawCamera, ok := root.take("camera"); ok {
+		if err := l.camera("$.camera", rawCamera, &scene.Camera); err != nil {
+			return nil, err
+		}
+	}
+	if rawEnvironment, ok := root.take("environment"); ok {
+		env, err := l.environment("$.environment", rawEnvironment)
+		if err != nil {
+			return nil, err
+		}
+		scene.Camera.Environment = env
+	}
+	var world []json.RawMessage
+	if err := root.required("world", &world); err != nil {
+		return nil, err
+	}
+	for i, rawObject := range world {
+		h, err := l.object(fmt.Sprintf("$.world[%d]", i), rawObject)
+		if err != nil {
+			return nil, err
+		}
+		scene.World.Add(h)
+	}
+	if err := root.finish(); err != nil {
+		return nil, err
+	}
+
+	// build definitions nothing referenced so mistakes in them still surface
+	for _, kind := range []string{"textures", "materials", "objects"} {
+		for _, name := range slices.Sorted(maps.Keys(l.defs[kind])) {
+			var err error
+			path := fmt.Sprintf("$.%s[%q]", kind, name)
+			switch kind {
+			case "textures":
+				_, err = l.namedTexture(path, name)
+			case "materials":
+				_, err = l.namedMaterial(path, name)
+			case "objects":
+				_, err = l.namedObject(path, name)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return scene, nil
+}
+
+// sceneNode is one JSON object being decoded. It remembers its path in the
+// document for error messages and which keys were consumed so typos are caught.
+type sceneNode struct {
+	path   string
+	fields map[string]json.RawMessage
+}
+
+func newSceneNode(path string, raw json.RawMessage) (*sceneNode, error) {
+	n := &sceneNode{path: path}
+	if err := json.Unmarshal(raw, &n.fields); err != nil || n.fields == nil {
+		return nil, fmt.Errorf("%s: expected an object", path)
+	}
+	return n, nil
+}
+
+func (n *sceneNode) errorf(key, format string, args ...any) error {
+	path := n.path
+	if key != "" {
+		path += "." + key
+	}
+	return fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...))
+}
+
+func (n *sceneNode) take(key string) (json.RawMessage, bool) {
+	raw, ok := n.fields[key]
+	delete(n.fields, key)
+	return raw, ok
+}
+
+func (n *sceneNode) optional(key string, dst any) error {
+	raw, ok := n.take(key)
+	if !ok {
+		return nil
+	}
+	if err := json.Unmarshal(raw, dst); err != nil {
+		return n.errorf(key, "expected %s", jsonKind(dst))
+	}
+	return nil
+}
+
+func (n *sceneNode) required(key string, dst any) error {
+	if _, ok := n.fields[key]; !ok {
+		return n.errorf(key, "missing required field")
+	}
+	return n.optional(key, dst)
+}
+
+func (n *sceneNode) vec(key string, dst *Vec3, required bool) error {
+	var v []float64
+	var err error
+	if required {
+		err = n.required(key, &v)
+	} else {
+		if _, ok := n.fields[key]; !ok {
+			return nil
+		}
+		err = n.optional(key, &v)
+	}
+	if err != nil {
+		return err
+	}
+	if len(v) != 3 {
+		return n.errorf(key, "expected 3 numbers, got %d", len(v))
+	}
+	*dst = NewVec3(v[0], v[1], v[2])
+	return nil
+}
+
+func (n *sceneNode) finish() error {
+	if keys := slices.Sorted(maps.Keys(n.fields)); len(keys) > 0 {
+		return n.errorf(keys[0], "unknown field")
+	}
+	return nil
+}
+
+func jsonKind(dst any) string {
+	switch dst.(type) {
+	case *float64, *int:
+		return "a number"
+	case *string:
+		return "a string"
+	case *bool:
+		return "a boolean"
+	case *[]float64:
+		return "an array of numbers"
+	case *[][]float64:
+		return "an array of number rows"
+	case *[]json.RawMessage:
+		return "an array"
+	}
+	return "an object"
+}
+
+type sceneLoader struct {
+	dir       string
+	rng       *rand.Rand
+	bvh       BVHOptions
+	textures  map[string]*Texture
+	materials map[string]*Material
+	objects   map[string]*Hittable
+	defs      map[string]map[string]json.RawMessage
+	building  map[string]bool // guards against reference cycles
+}
+
+func (l *sceneLoader) resolvePath(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(l.dir, p)
+}
+
+// reference reports whether raw is a string naming a definition.
+func reference(raw json.RawMessage) (string, bool) {
+	var name string
+	if len(bytes.TrimSpace(raw)) == 0 || bytes.TrimSpace(raw)[0] != '"' {
+		return "", false
+	}
+	if json.Unmarshal(raw, &name) != nil {
+		return "", false
+	}
+	return name, true
+}
+
+func (l *sceneLoader) camera(path string, raw json.RawMessage, c *Camera) error {
+	n, err := newSceneNode(path, raw)
+	if err != nil {
+		return err
+	}
+	for _, f := range []struct {
+		key string
+		dst any
+	}{
+		{"image_width", &c.ImageWidth},
+		{"aspect_ratio", &c.AspectRatio},
+		{"samples_per_pixel", &c.SamplesPerPixel},
+		{"max_depth", &c.MaxDepth},
+		{"vfov", &c.VFov},
+		{"defocus_angle", &c.DefocusAngle},
+		{"focus_distance", &c.FocusDistance},
+		{"adaptive_threshold", &c.AdaptiveThreshold},
+		{"min_samples", &c.MinSamples},
+	} {
+		if err := n.optional(f.key, f.dst); err != nil {
+			return err
+		}
+	}
+	for _, f := range []struct {
+		key string
+		dst *Vec3
+	}{
+		{"look_from", &c.LookFrom},
+		{"look_at", &c.LookAt},
+		{"vup", &c.VUP},
+		{"background", &c.Background},
+	} {
+		if err := n.vec(f.key, f.dst, false); err != nil {
+			return err
+		}
+	}
+	if c.ImageWidth <= 0 {
+		return fmt.Errorf("%s.image_width: must be positive", path)
+	}
+	if c.AspectRatio <= 0 {
+		return fmt.Errorf("%s.aspect_ratio: must be positive", path)
+	}
+	if c.SamplesPerPixel <= 0 {
+		return fmt.Errorf("%s.samples_per_pixel: must be positive", path)
+	}
+	if c.MaxDepth <= 0 {
+		return fmt.Errorf("%s.max_depth: must be positive", path)
+	}
+	if c.VFov <= 0 || c.VFov >= 180 {
+		return fmt.Errorf("%s.vfov: must be between 0 and 180", path)
+	}
+	if c.AdaptiveThreshold < 0 {
+		return fmt.Errorf("%s.adaptive_threshold: must not be negative", path)
+	}
+	if c.MinSamples <= 0 {
+		return fmt.Errorf("%s.min_samples: must be positive", path)
+	}
+	return n.finish()
+}
+
+// environment reads the light around the scene: {"type": "constant", "color"},
+// {"type": "gradient", "bottom", "top"}, {"type": "map", "file", "rotation",
+// "intensity"} with an equirectangular .hdr, .pfm or .exr image, or
+// {"type": "sky", "sun_elevation", "sun_azimuth", "turbidity", "sun_radius",
+// "intensity"} for a Preetham daylight sky and sun.
+func (l *sceneLoader) environment(path string, raw json.RawMessage) (Environment, error) {
+	n, err := newSceneNode(path, raw)
+	if err != nil {
+		return nil, err
+	}
+	var kind string
+	if err := n.required("type", &kind); err != nil {
+		return nil, err
+	}
+	var env Environment
+	switch kind {
+	case "constant":
+		var c Vec3
+		if err := n.vec("color", &c, true); err != nil {
+			return nil, err
+		}
+		env = &ConstantEnvironment{Color: c}
+	case "gradient":
+		g := &GradientEnvironment{Bottom: NewVec3(1, 1, 1), Top: NewVec3(0.5, 0.7, 1)}
+		if err := n.vec("bottom", &g.Bottom, false); err != nil {
+			return nil, err
+		}
+		if err := n.vec("top", &g.Top, false); err != nil {
+			return nil, err
+		}
+		env = g
+	case "map":
+		var file string
+		rotation, intensity := 0.0, 1.0
+		if err := n.required("file", &file); err != nil {
+			return nil, err
+		}
+		if err := n.optional("rotation", &rotation); err != nil {
+			return nil, err
+		}
+		if err := n.optional("intensity", &intensity); err != nil {
+			return nil, err
+		}
+		if intensity < 0 {
+			return nil, n.errorf("intensity", "must not be negative")
+		}
+		if env, err = LoadEnvironmentMap(l.resolvePath(file), rotation, intensity); err != nil {
+			return nil, n.errorf("file", "%v", err)
+		}
+	case "sky":
+		sky := DefaultSky
+		for _, f := range []struct {
+			key string
+			dst *float64
+		}{
+			{"sun_elevation", &sky.Elevation},
+			{"sun_azimuth", &sky.Azimuth},
+			{"turbidity", &sky.Turbidity},
+			{"sun_radius", &sky.SunRadius},
+			{"intensity", &sky.Intensity},
+		} {
+			if err := n.optional(f.key, f.dst); err != nil {
+				return nil, err
+			}
+		}
+		if err := sky.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		env = NewPreethamSky(sky)
+	default:
+		return nil, n.errorf("type", "unknown environment type %q", kind)
+	}
+	return env, n.finish()
+}
+
+func (l *sceneLoader) namedTexture(path, name string) (*Texture, error) {
+	if t, ok := l.textures[name]; ok {
+		return t, nil
+	}
+	raw, ok := l.defs["textures"][name]
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown texture %q", path, name)
+	}
+	key := "textures/" + name
+	if l.building[key] {
+		return nil, fmt.Errorf("%s: texture %q refers to itself", path, name)
+	}
+	l.building[key] = true
+	t, err := l.texture(fmt.Sprintf("$.textures[%q]", name), raw)
+	if err != nil {
+		return nil, err
+	}
+	l.textures[name] = t
+	return t, nil
+}
+
+// texture accepts a name, an [r, g, b] color or an inline texture object.
+func (l *sceneLoader) texture(path string, raw json.RawMessage) (*Texture, error) {
+	if name, ok := reference(raw); ok {
+		return l.namedTexture(path, name)
+	}
+	var color []float64
+	if json.Unmarshal(raw, &color) == nil && color != nil {
+		if len(color) != 3 {
+			return nil, fmt.Errorf("%s: expected 3 numbers, got %d", path, len(color))
+		}
+		return NewSolidColor(NewVec3(color[0], color[1], color[2])), nil
+	}
+
+	n, err := newSceneNode(path, raw)
+	if err != nil {
+		return nil, err
+	}
+	var kind string
+	if err := n.required("type", &kind); err != nil {
+		return nil, err
+	}
+	var t *Texture
+	switch kind {
+	case "solid":
+		var c Vec3
+		if err := n.vec("color", &c, true); err != nil {
+			return nil, err
+		}
+		t = NewSolidColor(c)
+	case "checker":
+		var scale float64
+		if err := n.required("scale", &scale); err != nil {
+			return nil, err
+		}
+		var even, odd *Texture
+		for _, f := range []struct {
+			key string
+			dst **Texture
+		}{{"even", &even}, {"odd", &odd}} {
+			rawTex, ok := n.take(f.key)
+			if !ok {
+				return nil, n.errorf(f.key, "missing required field")
+			}
+			if *f.dst, err = l.texture(path+"."+f.key, rawTex); err != nil {
+				return nil, err
+			}
+		}
+		t = NewCheckeredTextureFromTexture(scale, even, odd)
+	case "noise":
+		var scale float64
+		if err := n.required("scale", &scale); err != nil {
+			return nil, err
+		}
+		t = NewNoiseTexture(l.rng, scale)
+	case "image":
+		var file string
+		var linear bool // values such as roughness rather than sRGB colors
+		if err := n.required("file", &file); err != nil {
+			return nil, err
+		}
+		if err := n.optional("linear", &linear); err != nil {
+			return nil, err
+		}
+		load := LoadImageTexture
+		if linear {
+			load = LoadDataTexture
+		}
+		if t, err = load(l.resolvePath(file)); err != nil {
+			return nil, n.errorf("file", "%v", err)
+		}
+	default:
+		return nil, n.errorf("type", "unknown texture type %q", kind)
+	}
+	return t, n.finish()
+}
+
+func (l *sceneLoader) namedMaterial(path, name string) (*Material, error) {
+	if m, ok := l.materials[name]; ok {
+		return m, nil
+	}
+	raw, ok := l.defs["materials"][name]
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown material %q", path, name)
+	}
+	key := "materials/" + name
+	if l.building[key] {
+		return nil, fmt.Errorf("%s: material %q refers to itself", path, name)
+	}
+	l.building[key] = true
+	m, err := l.material(fmt.Sprintf("$.materials[%q]", name), raw)
+	if err != nil {
+		return nil, err
+	}
+	l.materials[name] = m
+	return m, nil
+}
+
+func (l *sceneLoader) material(path string, raw json.RawMessage) (*Material, error) {
+	if name, ok := reference(raw); ok {
+		return l.namedMaterial(path, name)
+	}
+	n, err := newSceneNode(path, raw)
+	if err != nil {
+		return nil, err
+	}
+	var kind string
+	if err := n.required("type", &kind); err != nil {
+		return nil, err
+	}
+	textureField := func(key string) (*Texture, error) {
+		rawTex, ok := n.take(key)
+		if !ok {
+			return nil, n.errorf(key, "missing required field")
+		}
+		return l.texture(path+"."+key, rawTex)
+	}
+
+	// scalar parameters are a number from 0 to 1 or, to vary over the surface,
+	// a texture whose red channel is read; nil when absent
+	scalarField := func(key string) (*Texture, error) {
+		rawScalar, ok := n.take(key)
+		if !ok {
+			return nil, nil
+		}
+		var v float64
+		if json.Unmarshal(rawScalar, &v) == nil {
+			if v < 0 || v > 1 {
+				return nil, n.errorf(key, "must be between 0 and 1")
+			}
+			return scalarTexture(v), nil
+		}
+		return l.texture(path+"."+key, rawScalar)
+	}
+	roughnessField := func() (*Texture, error) {
+		if _, ok := n.fields["roughness"]; !ok {
+			return nil, n.errorf("roughness", "missing required field")
+		}
+		return scalarField("roughness")
+	}
+
+	var m *Material
+	switch kind {
+	case "lambertian":
+		t, err := textureField("albedo")
+		if err != nil {
+			return nil, err
+		}
+		m = NewLambertianFromTexture(t)
+	case "oren_nayar":
+		t, err := textureField("albedo")
+		if err != nil {
+			return nil, err
+		}
+		// sigma is in radians, a number or a texture read through its red channel
+		sigma := scalarTexture(0)
+		if rawSigma, ok := n.take("sigma"); ok {
+			var v float64
+			if json.Unmarshal(rawSigma, &v) == nil {
+				if v < 0 || v > math.Pi/2 {
+					return nil, n.errorf("sigma", "must be between 0 and pi/2 radians")
+				}
+				sigma = scalarTexture(v)
+			} else if sigma, err = l.texture(path+".sigma", rawSigma); err != nil {
+				return nil, err
+			}
+		}
+		m = NewOrenNayarFromTextures(t, sigma)
+	case "metal":
+		var albedo Vec3
+		var fuzz float64
+		if err := n.vec("albedo", &albedo, true); err != nil {
+			return nil, err
+		}
+		if err := n.optional("fuzz", &fuzz); err != nil {
+			return nil, err
+		}
+		m = NewMetal(albedo, fuzz)
+	case "dielectric":
+		var ior float64
+		if err := n.required("ior", &ior); err != nil {
+			return nil, err
+		}
+		m = NewDielectric(ior)
+	case "rough_conductor":
+		// the reflectance comes from the albedo, a named metal or its
+		// measured eta and k; with either of the last the albedo is a tint
+		var ior *ComplexIOR
+		var metal string
+		if err := n.optional("metal", &metal); err != nil {
+			return nil, err
+		}
+		_, hasEta := n.fields["eta"]
+		_, hasK := n.fields["k"]
+		switch {
+		case metal != "" && (hasEta || hasK):
+			return nil, n.errorf("metal", "give either a metal or eta and k")
+		case metal != "":
+			preset, ok := Conductors[metal]
+			if !ok {
+				return nil, n.errorf("metal", "unknown metal %q (known: %v)", metal, slices.Sorted(maps.Keys(Conductors)))
+			}
+			ior = &preset
+		case hasEta || hasK:
+			ior = &ComplexIOR{}
+			if err := n.vec("eta", &ior.Eta, true); err != nil {
+				return nil, err
+			}
+			if err := n.vec("k", &ior.K, true); err != nil {
+				return nil, err
+			}
+		}
+		t := NewSolidColor(NewVec3(1, 1, 1))
+		if _, ok := n.fields["albedo"]; ok || ior == nil {
+			if t, err = textureField("albedo"); err != nil {
+				return nil, err
+			}
+		}
+		roughness, err := roughnessField()
+		if err != nil {
+			return nil, err
+		}
+		if ior != nil {
+			m = NewConductorFromTextures(*ior, t, roughness)
+		} else {
+			m = NewRoughConductorFromTextures(t, roughness)
+		}
+	case "rough_dielectric":
+		var ior float64
+		if err := n.required("ior", &ior); err != nil {
+			return nil, err
+		}
+		if ior <= 0 {
+			return nil, n.errorf("ior", "must be positive")
+		}
+		roughness, err := roughnessField()
+		if err != nil {
+			return nil, err
+		}
+		m = NewRoughDielectricFromTexture(ior, roughness)
+	case "principled":
+		var p Principled
+		if _, ok := n.fields["base_color"]; ok {
+			if p.BaseColor, err = textureField("base_color"); err != nil {
+				return nil, err
+			}
+		}
+		for _, f := range []struct {
+			key string
+			dst **Texture
+		}{
+			{"metallic", &p.Metallic},
+			{"roughness", &p.Roughness},
+			{"specular", &p.Specular},
+			{"clearcoat", &p.Clearcoat},
+			{"clearcoat_roughness", &p.ClearcoatRoughness},
+			{"transmission", &p.Transmission},
+		} {
+			if *f.dst, err = scalarField(f.key); err != nil {
+				return nil, err
+			}
+		}
+		if _, ok := n.fields["ior"]; ok {
+			if err := n.optional("ior", &p.IOR); err != nil {
+				return nil, err
+			}
+			if p.IOR <= 0 {
+				return nil, n.errorf("ior", "must be positive")
+			}
+		}
+		m = NewPrincipledMaterial(p)
+	case "coated":
+		c := CoatedDiffuse{CoatColor: NewVec3(1, 1, 1)}
+		if c.Tex, err = textureField("albedo"); err != nil {
+			return nil, err
+		}
+		if c.Roughness, err = scalarField("roughness"); err != nil {
+			return nil, err
+		}
+		if _, ok := n.fields["ior"]; ok {
+			if err := n.optional("ior", &c.IOR); err != nil {
+				return nil, err
+			}
+			if c.IOR <= 0 {
+				return nil, n.errorf("ior", "must be positive")
+			}
+		}
+		if err := n.vec("coat_color", &c.CoatColor, false); err != nil {
+			return nil, err
+		}
+		if c.CoatColor.X < 0 || c.CoatColor.X > 1 || c.CoatColor.Y < 0 || c.CoatColor.Y > 1 || c.CoatColor.Z < 0 || c.CoatColor.Z > 1 {
+			return nil, n.errorf("coat_color", "components must be between 0 and 1")
+		}
+		if err := n.optional("thickness", &c.Thickness); err != nil {
+			return nil, err
+		}
+		if c.Thickness < 0 {
+			return nil, n.errorf("thickness", "must not be negative")
+		}
+		m = NewCoatedDiffuseMaterial(c)
+	case "diffuse_light":
+		t, err := textureField("emit")
+		if err != nil {
+			return nil, err
+		}
+		m = NewDiffuseLight(t)
+	case "isotropic":
+		t, err := textureField("albedo")
+		if err != nil {
+			return nil, err
+		}
+		m = NewIsotropicFromTexture(t)
+	default:
+		return nil, n.errorf("type", "unknown material type %q", kind)
+	}
+	return m, n.finish()
+}
+
+func (l *sceneLoader) namedObject(path, name string) (*Hittable, error) {
+	if h, ok := l.objects[name]; ok {
+		return h, nil
+	}
+	raw, ok := l.defs["objects"][name]
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown object %q", path, name)
+	}
+	key := "objects/" + name
+	if l.building[key] {
+		return nil, fmt.Errorf("%s: object %q refers to itself", path, name)
+	}
+	l.building[key] = true
+	h, err := l.object(fmt.Sprintf("$.objects[%q]", name), raw)
+	if err != nil {
+		return nil, err
+	}
+	l.objects[name] = h
+	return h, nil
+}
+
+func (l *sceneLoader) object(path string, raw json.RawMessage) (*Hittable, error) {
+	if name, ok := reference(raw); ok {
+		return l.namedObject(path, name)
+	}
+	n, err := newSceneNode(path, raw)
+	if err != nil {
+		return nil, err
+	}
+	var kind string
+	if err := n.required("type", &kind); err != nil {
+		return nil, err
+	}
+	materialField := func() (*Material, error) {
+		rawMat, ok := n.take("material")
+		if !ok {
+			return nil, n.errorf("material", "missing required field")
+		}
+		return l.material(path+".material", rawMat)
+	}
+	objectField := func(key string) (*Hittable, error) {
+		rawObj, ok := n.take(key)
+		if !ok {
+			return nil, n.errorf(key, "missing required field")
+		}
+		return l.object(path+"."+key, rawObj)
+	}
+	listField := func(key string) (*HittableList, error) {
+		var items []json.RawMessage
+		if err := n.required(key, &items); err != nil {
+			return nil, err
+		}
+		list := NewHittableList()
+		for i, item := range items {
+			h, err := l.object(fmt.Sprintf("%s.%s[%d]", path, key, i), item)
+			if err != nil {
+				return nil, err
+			}
+			list.Add(h)
+		}
+		if len(list.Objects) == 0 {
+			return nil, n.errorf(key, "must not be empty")
+		}
+		return list, nil
+	}
+
+	var h *Hittable
+	switch kind {
+	case "sphere":
+		var center, center2 Vec3
+		var radius float64
+		if err := n.vec("center", &center, true); err != nil {
+			return nil, err
+		}
+		_, moving := n.fields["center2"]
+		if err := n.vec("center2", &center2, false); err != nil {
+			return nil, err
+		}
+		if err := n.required("radius", &radius); err != nil {
+			return nil, err
+		}
+		m, err := materialField()
+		if err != nil {
+			return nil, err
+		}
+		if moving {
+			h = NewMovingSphere(center, center2, radius, m)
+		} else {
+			h = NewSphere(center, radius, m)
+		}
+	case "quad":
+		var q, u, v Vec3
+		for _, f := range []struct {
+			key string
+			dst *Vec3
+		}{{"q", &q}, {"u", &u}, {"v", &v}} {
+			if err := n.vec(f.key, f.dst, true); err != nil {
+				return nil, err
+			}
+		}
+		m, err := materialField()
+		if err != nil {
+			return nil, err
+		}
+		h = NewQuad(q, u, v, m)
+	case "triangle":
+		var a, b, c Vec3
+		for _, f := range []struct {
+			key string
+			dst *Vec3
+		}{{"a", &a}, {"b", &b}, {"c", &c}} {
+			if err := n.vec(f.key, f.dst, true); err != nil {
+				return nil, err
+			}
+		}
+		m, err := materialField()
+		if err != nil {
+			return nil, err
+		}
+		h = NewTriangle(a, b, c, m)
+	case "box":
+		var a, b Vec3
+		if err := n.vec("min", &a, true); err != nil {
+			return nil, err
+		}
+		if err := n.vec("max", &b, true); err != nil {
+			return nil, err
+		}
+		m, err := materialField()
+		if err != nil {
+			return nil, err
+		}
+		h = NewBox(a, b, m)
+	case "obj":
+		var file string
+		if err := n.required("file", &file); err != nil {
+			return nil, err
+		}
+		list, err := LoadOBJ(l.resolvePath(file), l.bvh)
+		if err != nil {
+			return nil, n.errorf("file", "%v", err)
+		}
+		h = list.Objects[0]
+	case "list", "bvh":
+		list, err := listField("objects")
+		if err != nil {
+			return nil, err
+		}
+		if kind == "bvh" {
+			h = NewBVHNodeFromList(list, l.bvh)
+		} else {
+			lh := Hittable(list)
+			h = &lh
+		}
+	case "constant_medium":
+		boundary, err := objectField("boundary")
+		if err != nil {
+			return nil, err
+		}
+		var density float64
+		if err := n.required("density", &density); err != nil {
+			return nil, err
+		}
+		if density <= 0 {
+			return nil, n.errorf("density", "must be positive")
+		}
+		rawTex, ok := n.take("albedo")
+		if !ok {
+			return nil, n.errorf("albedo", "missing required field")
+		}
+		t, err := l.texture(path+".albedo", rawTex)
+		if err != nil {
+			return nil, err
+		}
+		h = NewConstantMedium(boundary, density, t)
+	case "translate":
+		object, err := objectField("object")
+		if err != nil {
+			return nil, err
+		}
+		var offset Vec3
+		if err := n.vec("offset", &offset, true); err != nil {
+			return nil, err
+		}
+		if h, err = NewTranslate(object, offset); err != nil {
+			return nil, n.errorf("offset", "%v", err)
+		}
+	case "rotate_y":
+		object, err := objectField("object")
+		if err != nil {
+			return nil, err
+		}
+		var angle float64
+		if err := n.required("angle", &angle); err != nil {
+			return nil, err
+		}
+		if h, err = NewRotateY(object, angle); err != nil {
+			return nil, n.errorf("angle", "%v", err)
+		}
+	case "rotate":
+		object, err := objectField("object")
+		if err != nil {
+			return nil, err
+		}
+		var axis Vec3
+		var angle float64
+		if err := n.vec("axis", &axis, true); err != nil {
+			return nil, err
+		}
+		if axis.NearZero() {
+			return nil, n.errorf("axis", "must not be zero")
+		}
+		if err := n.required("angle", &angle); err != nil {
+			return nil, err
+		}
+		if h, err = NewRotate(object, axis, angle); err != nil {
+			return nil, n.errorf("angle", "%v", err)
+		}
+	case "scale":
+		object, err := objectField("object")
+		if err != nil {
+			return nil, err
+		}
+		var factors Vec3
+		if err := n.vec("factors", &factors, true); err != nil {
+			return nil, err
+		}
+		if h, err = NewScale(object, factors); err != nil {
+			return nil, n.errorf("factors", "must not be zero")
+		}
+	case "transform":
+		object, err := objectField("object")
+		if err != nil {
+			return nil, err
+		}
+		var ops []json.RawMessage
+		if err := n.required("ops", &ops); err != nil {
+			return nil, err
+		}
+		m := IdentityMat4()
+		for i, rawOp := range ops {
+			op, err := l.transformOp(fmt.Sprintf("%s.ops[%d]", path, i), rawOp)
+			if err != nil {
+				return nil, err
+			}
+			m = op.Mul(m) // later ops apply after earlier ones
+		}
+		t, err := NewTransform(object, m)
+		if err != nil {
+			return nil, n.errorf("ops", "%v", err)
+		}
+		th := Hittable(t)
+		h = &th
+	default:
+		return nil, n.errorf("type", "unknown object type %q", kind)
+	}
+
+	var name string
+	if err := n.optional("name", &name); err != nil {
+		return nil, err
+	}
+	if name != "" {
+		if _, exists := l.objects[name]; exists || l.defs["objects"][name] != nil {
+			return nil, n.errorf("name", "object %q is already defined", name)
+		}
+		l.objects[name] = h
+	}
+	return h, n.finish()
+}
+
+// transformOp reads one step of a "transform" object: {"translate": [x, y, z]},
+// {"scale": [x, y, z]}, {"rotate": {"axis": [x, y, z], "angle": degrees}} or a
+// row-major {"matrix": [[...], [...], [...], [...]]}, which must be an
+// invertible affine transform.
+func (l *sceneLoader) transformOp(path string, raw json.RawMessage) (Mat4, error) {
+	n, err := newSceneNode(path, raw)
+	if err != nil {
+		return Mat4{}, err
+	}
+	if len(n.fields) != 1 {
+		return Mat4{}, fmt.Errorf("%s: expected exactly one of translate, rotate, scale or matrix", path)
+	}
+	var m Mat4
+	switch {
+	case n.fields["translate"] != nil:
+		var offset Vec3
+		if err := n.vec("translate", &offset, true); err != nil {
+			return Mat4{}, err
+		}
+		m = TranslationMat4(offset)
+	case n.fields["scale"] != nil:
+		var factors Vec3
+		if err := n.vec("scale", &factors, true); err != nil {
+			return Mat4{}, err
+		}
+		m = ScaleMat4(factors)
+	case n.fields["rotate"] != nil:
+		rawRotate, _ := n.take("rotate")
+		r, err := newSceneNode(path+".rotate", rawRotate)
+		if err != nil {
+			return Mat4{}, err
+		}
+		var axis Vec3
+		var angle float64
+		if err := r.vec("axis", &axis, true); err != nil {
+			return Mat4{}, err
+		}
+		if axis.NearZero() {
+			return Mat4{}, r.errorf("axis", "must not be zero")
+		}
+		if err := r.required("angle", &angle); err != nil {
+			return Mat4{}, err
+		}
+		if err := r.finish(); err != nil {
+			return Mat4{}, err
+		}
+		if m, err = RotationMat4(axis, angle); err != nil {
+			return Mat4{}, r.errorf("axis", "%v", err)
+		}
+	case n.fields["matrix"] != nil:
+		var rows [][]float64
+		if err := n.required("matrix", &rows); err != nil {
+			return Mat4{}, err
+		}
+		if len(rows) != 4 {
+			return Mat4{}, n.errorf("matrix", "expected 4 rows, got %d", len(rows))
+		}
+		for i, row := range rows {
+			if len(row) != 4 {
+				return Mat4{}, n.errorf("matrix", "row %d: expected 4 numbers, got %d", i, len(row))
+			}
+			copy(m[i][:], row)
+		}
+		if m[3] != [4]float64{0, 0, 0, 1} {
+			return Mat4{}, n.errorf("matrix", "last row must be [0, 0, 0, 1]")
+		}
+		if _, ok := m.Inverse(); !ok {
+			return Mat4{}, n.errorf("matrix", "must be invertible")
+		}
+	}
+	return m, n.finish()
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sceneWith wraps world objects and top-level fields into a scene document.
+func sceneWith(world string, fields ...string) string {
+	return fmt.Sprintf(`{%s"world": [%s]}`, strings.Join(append(fields, ""), ", "), world)
+}
+
+const (
+	sceneSphere = `{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": {"type": "lambertian", "albedo": [0.5, 0.5, 0.5]}}`
+	sceneGrey   = `{"type": "lambertian", "albedo": [0.5, 0.5, 0.5]}`
+)
+
+func TestParseScene(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		scene string
+		check func(*Scene) error
+	}{
+		{"sphere", sceneWith(sceneSphere), nil},
+		{"camera", sceneWith(sceneSphere, `"camera": {"image_width": 64, "samples_per_pixel": 3, "look_from": [1, 2, 3]}`),
+			func(s *Scene) error {
+				if c := s.Camera; c.ImageWidth != 64 || c.SamplesPerPixel != 3 || c.LookFrom != NewVec3(1, 2, 3) || c.Seed != 7 {
+					return fmt.Errorf("got camera %+v", c)
+				}
+				return nil
+			}},
+		{"named definitions", sceneWith(`"ball", {"type": "sphere", "center": [0, 3, 0], "radius": 1, "material": "checker"}`,
+			`"textures": {"tiles": {"type": "checker", "scale": 0.5, "even": [1, 1, 1], "odd": "dark"}, "dark": [0.1, 0.1, 0.1]}`,
+			`"materials": {"checker": {"type": "lambertian", "albedo": "tiles"}}`,
+			`"objects": {"ball": {"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": "checker"}}`), nil},
+		{"every material", sceneWith(`{"type": "list", "objects": [` + strings.Join([]string{
+			`{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": {"type": "oren_nayar", "albedo": [1, 1, 1], "sigma": 0.3}}`,
+			`{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": {"type": "metal", "albedo": [1, 1, 1], "fuzz": 0.1}}`,
+			`{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": {"type": "dielectric", "ior": 1.5}}`,
+			`{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": {"type": "rough_conductor", "metal": "Au", "roughness": 0.2}}`,
+			`{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": {"type": "rough_conductor", "eta": [1, 1, 1], "k": [2, 2, 2], "roughness": 0.2}}`,
+			`{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": {"type": "rough_dielectric", "ior": 1.5, "roughness": 0.1}}`,
+			`{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": {"type": "principled", "base_color": [1, 0, 0], "metallic": 1, "roughness": {"type": "noise", "scale": 2}}}`,
+			`{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": {"type": "coated", "albedo": [1, 1, 1], "coat_color": [0, 0.5, 1], "thickness": 1}}`,
+			`{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": {"type": "diffuse_light", "emit": [4, 4, 4]}}`,
+		}, ", ") + `]}`), nil},
+		{"every object", sceneWith(strings.Join([]string{
+			`{"type": "sphere", "center": [0, 0, 0], "center2": [0, 1, 0], "radius": 1, "material": "grey"}`,
+			`{"type": "quad", "q": [0, 0, 0], "u": [1, 0, 0], "v": [0, 1, 0], "material": "grey"}`,
+			`{"type": "triangle", "a": [0, 0, 0], "b": [1, 0, 0], "c": [0, 1, 0], "material": "grey"}`,
+			`{"type": "constant_medium", "boundary": {"type": "box", "min": [0, 0, 0], "max": [1, 1, 1], "material": "grey"}, "density": 0.5, "albedo": [1, 1, 1]}`,
+			`{"type": "bvh", "objects": [{"type": "translate", "offset": [1, 0, 0], "object": "ball"}, {"type": "rotate_y", "angle": 30, "object": "ball"}]}`,
+			`{"type": "rotate", "axis": [1, 1, 0], "angle": 45, "object": "ball"}`,
+			`{"type": "scale", "factors": [1, 2, 3], "object": "ball"}`,
+			`{"type": "transform", "object": "ball", "ops": [{"scale": [2, 2, 2]}, {"rotate": {"axis": [0, 1, 0], "angle": 90}}, {"translate": [1, 0, 0]},
+				{"matrix": [[1, 0, 0, 0], [0, 1, 0, 0], [0, 0, 1, 0], [0, 0, 0, 1]]}]}`,
+		}, ", "), `"materials": {"grey": `+sceneGrey+`}`, `"objects": {"ball": {"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": "grey"}}`),
+			func(s *Scene) error {
+				if len(s.World.Objects) != 8 {
+					return fmt.Errorf("got %d objects, want 8", len(s.World.Objects))
+				}
+				return nil
+			}},
+		{"named inline object", sceneWith(`{"type": "sphere", "name": "ball", "center": [0, 0, 0], "radius": 1, "material": "grey"}, "ball"`,
+			`"materials": {"grey": `+sceneGrey+`}`), nil},
+		{"environments", sceneWith(sceneSphere, `"environment": {"type": "sky", "sun_elevation": 30}`), func(s *Scene) error {
+			if _, ok := s.Camera.Environment.(*PreethamSky); !ok {
+				return fmt.Errorf("got environment %T", s.Camera.Environment)
+			}
+			return nil
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := ParseScene([]byte(test.scene), t.TempDir(), 7, DefaultBVHOptions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.check != nil {
+				if err := test.check(s); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
+
+func TestParseSceneErrors(t *testing.T) {
+	for _, test := range []struct {
+		name, scene, err string
+	}{
+		{"not json", `{"world": [`, "scene: unexpected end of JSON input"},
+		{"not an object", `[]`, "$: expected an object"},
+		{"no world", `{}`, "$.world: missing required field"},
+		{"unknown top-level field", sceneWith(sceneSphere, `"camra": {}`), "$.camra: unknown field"},
+		{"unknown camera field", sceneWith(sceneSphere, `"camera": {"width": 10}`), "$.camera.width: unknown field"},
+		{"wrong kind", sceneWith(sceneSphere, `"camera": {"image_width": "wide"}`), "$.camera.image_width: expected a number"},
+		{"bad width", sceneWith(sceneSphere, `"camera": {"image_width": 0}`), "$.camera.image_width: must be positive"},
+		{"bad depth", sceneWith(sceneSphere, `"camera": {"max_depth": 0}`), "$.camera.max_depth: must be positive"},
+		{"bad vfov", sceneWith(sceneSphere, `"camera": {"vfov": 180}`), "$.camera.vfov: must be between 0 and 180"},
+		{"short vector", sceneWith(sceneSphere, `"camera": {"look_at": [0, 1]}`), "$.camera.look_at: expected 3 numbers, got 2"},
+		{"unknown object type", sceneWith(`{"type": "cone"}`), `$.world[0].type: unknown object type "cone"`},
+		{"missing field", sceneWith(`{"type": "sphere", "center": [0, 0, 0], "material": "grey"}`), "$.world[0].radius: missing required field"},
+		{"unknown material", sceneWith(`{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": "grey"}`), `$.world[0].material: unknown material "grey"`},
+		{"unknown material type", sceneWith(`{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": {"type": "wood"}}`),
+			`$.world[0].material.type: unknown material type "wood"`},
+		{"scalar out of range", sceneWith(`{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": {"type": "principled", "metallic": 2}}`),
+			"$.world[0].material.metallic: must be between 0 and 1"},
+		{"bright coat", sceneWith(`{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": {"type": "coated", "albedo": [1, 1, 1], "coat_color": [1, 2, 1]}}`),
+			"$.world[0].material.coat_color: components must be between 0 and 1"},
+		{"metal and eta", sceneWith(`{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": {"type": "rough_conductor", "metal": "Au", "eta": [1, 1, 1], "k": [1, 1, 1], "roughness": 0}}`),
+			"$.world[0].material.metal: give either a metal or eta and k"},
+		{"unknown texture", sceneWith(`{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": {"type": "lambertian", "albedo": "wood"}}`),
+			`$.world[0].material.albedo: unknown texture "wood"`},
+		{"missing image", sceneWith(`{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": {"type": "lambertian", "albedo": {"type": "image", "file": "none.png"}}}`),
+			"$.world[0].material.albedo.file: open"},
+		{"error in a definition", sceneWith(sceneSphere, `"materials": {"grey": {"type": "lambertian"}}`), `$.materials["grey"].albedo: missing required field`},
+		{"unused definition", sceneWith(sceneSphere, `"textures": {"spare": {"type": "solid"}}`), `$.textures["spare"].color: missing required field`},
+		{"reference cycle", sceneWith(`"a"`, `"objects": {"a": {"type": "translate", "offset": [0, 0, 0], "object": "b"}, "b": {"type": "translate", "offset": [0, 0, 0], "object": "a"}}`),
+			`$.objects["b"].object: object "a" refers to itself`},
+		{"duplicate name", sceneWith(`{"type": "sphere", "name": "a", "center": [0, 0, 0], "radius": 1, "material": "grey"}`,
+			`"materials": {"grey": `+sceneGrey+`}`, `"objects": {"a": `+sceneSphere+`}`), `$.world[0].name: object "a" is already defined`},
+		{"empty list", sceneWith(`{"type": "bvh", "objects": []}`), "$.world[0].objects: must not be empty"},
+		{"zero axis", sceneWith(`{"type": "rotate", "axis": [0, 0, 0], "angle": 10, "object": ` + sceneSphere + `}`), "$.world[0].axis: must not be zero"},
+		{"zero scale", sceneWith(`{"type": "scale", "factors": [1, 0, 1], "object": ` + sceneSphere + `}`), "$.world[0].factors: must not be zero"},
+		{"singular transform", sceneWith(`{"type": "transform", "ops": [{"scale": [0, 1, 1]}], "object": ` + sceneSphere + `}`), "$.world[0].ops: "},
+		{"two ops in one", sceneWith(`{"type": "transform", "ops": [{"scale": [1, 1, 1], "translate": [0, 0, 0]}], "object": ` + sceneSphere + `}`),
+			"$.world[0].ops[0]: expected exactly one of translate, rotate, scale or matrix"},
+		{"short matrix row", sceneWith(`{"type": "transform", "ops": [{"matrix": [[1, 0, 0, 0], [0, 1, 0], [0, 0, 1, 0], [0, 0, 0, 1]]}], "object": ` + sceneSphere + `}`),
+			"$.world[0].ops[0].matrix: row 1: expected 4 numbers, got 3"},
+		{"projective matrix", sceneWith(`{"type": "transform", "ops": [{"matrix": [[1, 0, 0, 0], [0, 1, 0, 0], [0, 0, 1, 0], [0, 0, 1, 1]]}], "object": ` + sceneSphere + `}`),
+			"$.world[0].ops[0].matrix: last row must be [0, 0, 0, 1]"},
+		{"singular matrix", sceneWith(`{"type": "transform", "ops": [{"matrix": [[1, 0, 0, 0], [0, 1, 0, 0], [1, 1, 0, 0], [0, 0, 0, 1]]}], "object": ` + sceneSphere + `}`),
+			"$.world[0].ops[0].matrix: must be invertible"},
+		{"bad density", sceneWith(`{"type": "constant_medium", "boundary": ` + sceneSphere + `, "density": 0, "albedo": [1, 1, 1]}`), "$.world[0].density: must be positive"},
+		{"unknown environment", sceneWith(sceneSphere, `"environment": {"type": "dome"}`), `$.environment.type: unknown environment type "dome"`},
+		{"missing mesh", sceneWith(`{"type": "obj", "file": "none.obj"}`), "$.world[0].file: open"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseScene([]byte(test.scene), t.TempDir(), 1, DefaultBVHOptions)
+			if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+				t.Fatalf("got error %v, want one starting %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestLoadExampleScenes(t *testing.T) {
+	paths, err := filepath.Glob("../scenes/*.json")
+	if err != nil || len(paths) == 0 {
+		t.Fatal("no example scenes", err)
+	}
+	for _, path := range paths {
+		if _, err := LoadScene(path, 1, DefaultBVHOptions); err != nil {
+			t.Errorf("%s: %v", path, err)
+		}
+	}
+}
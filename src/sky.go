@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/rand/v2"
+)
+
+// SkyOptions place the sun and set the atmosphere of a PreethamSky. Angles are
+// in degrees.
+type SkyOptions struct {
+	Elevation float64 // of the sun above the horizon
+	Azimuth   float64 // of the sun, from -Z towards +X
+	Turbidity float64
+	SunRadius float64
+	Intensity float64
+}
+
+var DefaultSky = SkyOptions{Elevation: 45, Azimuth: 30, Turbidity: 3, SunRadius: 0.27, Intensity: 1}
+
+func (o SkyOptions) Validate() error {
+	switch {
+	case o.Elevation < 0 || o.Elevation > 90:
+		return errors.New("sun elevation must be between 0 and 90 degrees")
+	case o.Turbidity < 1.7 || o.Turbidity > 10:
+		return errors.New("turbidity must be between 1.7 and 10, where the sky model holds")
+	case o.SunRadius <= 0 || o.SunRadius > 45:
+		return errors.New("sun radius must be above 0 and at most 45 degrees")
+	case o.Intensity < 0:
+		return errors.New("sky intensity must not be negative")
+	}
+	return nil
+}
+
+// Sun is a distant disk light. Its radiance is constant over the disk, so it
+// is sampled uniformly over the cone it subtends.
+type Sun struct {
+	Direction Vec3    // unit vector towards the sun
+	Radius    float64 // angular radius in degrees; the real sun's is about 0.27
+	Radiance  Vec3
+}
+
+// SunDirection points towards a sun at the given elevation above the horizon
+// and azimuth, in degrees, measured from -Z (straight ahead of the default
+// camera) towards +X.
+func SunDirection(elevation, azimuth float64) Vec3 {
+	e, a := DegreesToRadians(elevation), DegreesToRadians(azimuth)
+	return NewVec3(math.Sin(a)*math.Cos(e), math.Sin(e), -math.Cos(a)*math.Cos(e))
+}
+
+// oneMinusCosRadius is 1 - cos(Radius) without the cancellation of computing
+// it that way for a tiny disk.
+func (s *Sun) oneMinusCosRadius() float64 {
+	half := math.Sin(DegreesToRadians(s.Radius) / 2)
+	return 2 * half * half
+}
+
+func (s *Sun) Emitted(direction Vec3) Vec3 {
+	d := direction.GetUnitVec()
+	if 1-Dot(&d, &s.Direction) > s.oneMinusCosRadius() {
+		return Vec3{}
+	}
+	return s.Radiance
+}
+
+func (s *Sun) PDFValue(origin, direction Vec3) float64 {
+	d := direction.GetUnitVec()
+	cone := s.oneMinusCosRadius()
+	if 1-Dot(&d, &s.Direction) > cone {
+		return 0
+	}
+	return 1 / (2 * math.Pi * cone)
+}
+
+func (s *Sun) Random(origin Vec3, rng *rand.Rand) Vec3 {
+	r1, r2 := rng.Float64(), rng.Float64()
+	oneMinusZ := r2 * s.oneMinusCosRadius()
+	sinTheta := math.Sqrt(oneMinusZ * (2 - oneMinusZ))
+	phi := 2 * math.Pi * r1
+	local := NewVec3(math.Cos(phi)*sinTheta, math.Sin(phi)*sinTheta, 1-oneMinusZ)
+	return NewONB(s.Direction).Transform(local)
+}
+
+// PreethamSky is the analytic daylight model of Preetham, Shirley and Smits
+// (1999): the sky's luminance and chromaticity over the dome for a sun
+// position and atmospheric turbidity, plus the sun itself, dimmed and
+// reddened by the air it shines through. Directions below the horizon see
+// the horizon's color. Only the sun is sampled as a light; the sky is smooth
+// enough for BSDF sampling.
+type PreethamSky struct {
+	Sun       Sun
+	Turbidity float64 // haziness, from 2 for a very clear sky to 10 for a hazy one
+	Intensity float64 // scales sky and sun together
+
+	zenith        [3]float64 // Y, x and y straight up
+	perez         [3][5]float64
+	perezAtZenith [3]float64
+	scale         float64
+}
+
+// skyScale brings the model's kcd/m² into the renderer's units, where the old
+// default background of about 1 is a bright sky.
+const skyScale = 1.0 / 16
+
+// sunIrradiance is the sun's illuminance above the atmosphere, about 128 klx,
+// in the same units.
+const sunIrradiance = 128.0 / 16
+
+func NewPreethamSky(opts SkyOptions) *PreethamSky {
+	s := &PreethamSky{Turbidity: opts.Turbidity, Intensity: opts.Intensity, scale: opts.Intensity * skyScale}
+	s.Sun = Sun{Direction: SunDirection(opts.Elevation, opts.Azimuth), Radius: opts.SunRadius}
+	T := opts.Turbidity
+	thetaS := math.Pi/2 - DegreesToRadians(opts.Elevation)
+
+	chi := (4.0/9 - T/120) * (math.Pi - 2*thetaS)
+	s.zenith[0] = (4.0453*T-4.9710)*math.Tan(chi) - 0.2155*T + 2.4192
+	t3, t2 := thetaS*thetaS*thetaS, thetaS*thetaS
+	s.zenith[1] = T*T*(0.00166*t3-0.00375*t2+0.00209*thetaS) +
+		T*(-0.02903*t3+0.06377*t2-0.03202*thetaS+0.00394) +
+		(0.11693*t3 - 0.21196*t2 + 0.06052*thetaS + 0.25886)
+	s.zenith[2] = T*T*(0.00275*t3-0.00610*t2+0.00317*thetaS) +
+		T*(-0.04214*t3+0.08970*t2-0.04153*thetaS+0.00516) +
+		(0.15346*t3 - 0.26756*t2 + 0.06670*thetaS + 0.26688)
+
+	s.perez = [3][5]float64{
+		{0.1787*T - 1.4630, -0.3554*T + 0.4275, -0.0227*T + 5.3251, 0.1206*T - 2.5771, -0.0670*T + 0.3703},
+		{-0.0193*T - 0.2592, -0.0665*T + 0.0008, -0.0004*T + 0.2125, -0.0641*T - 0.8989, -0.0033*T + 0.0452},
+		{-0.0167*T - 0.2608, -0.0950*T + 0.0092, -0.0079*T + 0.2102, -0.0441*T - 1.6537, -0.0109*T + 0.0529},
+	}
+	for k := range 3 {
+		s.perezAtZenith[k] = perez(s.perez[k], 1, thetaS)
+	}
+
+	// the sun's disk carries the irradiance left after the atmosphere
+	transmittance := sunTransmittance(thetaS, T)
+	solidAngle := 2 * math.Pi * s.Sun.oneMinusCosRadius()
+	s.Sun.Radiance = transmittance.Scale(opts.Intensity * sunIrradiance / solidAngle)
+	return s
+}
+
+// perez is the Perez et al. distribution of sky luminance at a view zenith
+// cosine and angle gamma from the sun.
+func perez(c [5]float64, cosTheta, gamma float64) float64 {
+	cosGamma := math.Cos(gamma)
+	return (1 + c[0]*math.Exp(c[1]/cosTheta)) * (1 + c[2]*math.Exp(c[3]*gamma) + c[4]*cosGamma*cosGamma)
+}
+
+// sunTransmittance is the fraction of red, green and blue sunlight that gets
+// through Rayleigh and aerosol scattering along the path from the sun at
+// zenith angle thetaS, following the appendix of Preetham et al.
+func sunTransmittance(thetaS, turbidity float64) Vec3 {
+	zenithDegrees := thetaS * 180 / math.Pi
+	airMass := 1 / (math.Cos(thetaS) + 0.15*math.Pow(max(93.885-zenithDegrees, 1e-3), -1.253))
+	beta := 0.04608*turbidity - 0.04586 // Ångström's aerosol turbidity
+	var t [3]float64
+	for k, lambda := range [3]float64{0.680, 0.550, 0.440} { // wavelengths in µm
+		rayleigh := 0.008735 * math.Pow(lambda, -4.08)
+		aerosol := beta * math.Pow(lambda, -1.3)
+		t[k] = math.Exp(-airMass * (rayleigh + aerosol))
+	}
+	return NewVec3(t[0], t[1], t[2])
+}
+
+func (s *PreethamSky) Radiance(direction Vec3) Vec3 {
+	return s.SkyRadiance(direction).Add(s.Sun.Emitted(direction))
+}
+
+// SkyRadiance is the sky alone, without the sun's disk.
+func (s *PreethamSky) SkyRadiance(direction Vec3) Vec3 {
+	d := direction.GetUnitVec()
+	cosTheta := max(d.Y, 1e-3)
+	cosGamma := max(-1, min(1, Dot(&d, &s.Sun.Direction)))
+	gamma := math.Acos(cosGamma)
+	var Yxy [3]float64
+	for k := range 3 {
+		Yxy[k] = s.zenith[k] * perez(s.perez[k], cosTheta, gamma) / s.perezAtZenith[k]
+	}
+	return xyYToRGB(Yxy[1], Yxy[2], Yxy[0]).Scale(s.scale)
+}
+
+// xyYToRGB converts a CIE xyY color to linear sRGB.
+func xyYToRGB(x, y, Y float64) Vec3 {
+	if y <= 0 {
+		return Vec3{}
+	}
+	X, Z := x/y*Y, (1-x-y)/y*Y
+	return NewVec3(
+		max(3.2406*X-1.5372*Y-0.4986*Z, 0),
+		max(-0.9689*X+1.8758*Y+0.0415*Z, 0),
+		max(0.0557*X-0.2040*Y+1.0570*Z, 0),
+	)
+}
+
+func (s *PreethamSky) PDFValue(origin, direction Vec3) float64 {
+	return s.Sun.PDFValue(origin, direction)
+}
+
+func (s *PreethamSky) Random(origin Vec3, rng *rand.Rand) Vec3 {
+	return s.Sun.Random(origin, rng)
+}
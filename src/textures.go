@@ -8,6 +8,7 @@ import (
 	_ "image/png"
 	"log"
 	"math"
+	"math/rand/v2"
 	"os"
 )
 
@@ -60,8 +61,8 @@ type NoiseTexture struct {
 	Scale float64
 }
 
-func NewNoiseTexture(scale float64) *Texture {
-	t := Texture(&NoiseTexture{Noise: NewPerlinNoise(), Scale: scale})
+func NewNoiseTexture(rng *rand.Rand, scale float64) *Texture {
+	t := Texture(&NoiseTexture{Noise: NewPerlinNoise(rng), Scale: scale})
 	return &t
 }
 
@@ -91,14 +92,32 @@ type LoadedImage struct {
 }
 
 func NewImageTexture(filename string) *Texture {
-	file, err := os.Open(filename)
+	t, err := LoadImageTexture(filename)
 	if err != nil {
 		log.Fatal(err)
 	}
+	return t
+}
+
+func LoadImageTexture(filename string) (*Texture, error) {
+	return loadImageTexture(filename, SrgbToLinear)
+}
+
+// LoadDataTexture loads an image of values, such as a roughness map, which
+// unlike colors aren't sRGB encoded.
+func LoadDataTexture(filename string) (*Texture, error) {
+	return loadImageTexture(filename, func(c byte) float64 { return float64(c) / 255 })
+}
+
+func loadImageTexture(filename string, decode func(byte) float64) (*Texture, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
 	defer file.Close()
 	img, imgFmt, err := image.Decode(file)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("%s: %w", filename, err)
 	}
 	fmt.Printf("Decoding image (%s)\n", imgFmt)
 
@@ -116,13 +135,13 @@ func NewImageTexture(filename string) *Texture {
 		for x := range w {
 			c := color.NRGBAModel.Convert(img.At(x+bounds.Min.X, y+bounds.Min.Y)).(color.NRGBA)
 			i := (y*w + x) * 3
-			tex.img.Pixels[i+0] = SrgbToLinear(c.R)
-			tex.img.Pixels[i+1] = SrgbToLinear(c.G)
-			tex.img.Pixels[i+2] = SrgbToLinear(c.B)
+			tex.img.Pixels[i+0] = decode(c.R)
+			tex.img.Pixels[i+1] = decode(c.G)
+			tex.img.Pixels[i+2] = decode(c.B)
 		}
 	}
 	t := Texture(&tex)
-	return &t
+	return &t, nil
 }
 
 func (t *LoadedImage) PixelData(x, y float64) (r, g, b float64) {
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+)
+
+// ToneOperator compresses linear radiance into the displayable [0, 1] range.
+type ToneOperator int
+
+const (
+	ToneClamp            ToneOperator = iota // cut everything above 1
+	ToneReinhard                             // x / (1 + x)
+	ToneReinhardExtended                     // Reinhard that reaches white at WhitePoint
+	ToneACES                                 // Narkowicz's fit of the ACES filmic curve
+	ToneHable                                // Hable's Uncharted 2 filmic curve
+)
+
+var ToneOperators = map[string]ToneOperator{
+	"clamp":             ToneClamp,
+	"reinhard":          ToneReinhard,
+	"reinhard-extended": ToneReinhardExtended,
+	"aces":              ToneACES,
+	"hable":             ToneHable,
+}
+
+func ParseToneOperator(name string) (ToneOperator, error) {
+	op, ok := ToneOperators[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown tone operator %q (want one of %s)", name, strings.Join(slices.Sorted(maps.Keys(ToneOperators)), ", "))
+	}
+	return op, nil
+}
+
+// TransferFunction encodes tone-mapped values for the display.
+type TransferFunction int
+
+const (
+	EncodeSRGB   TransferFunction = iota // the sRGB OETF
+	EncodeGamma2                         // square root, as the renderer always used
+	EncodeLinear                         // none, for data that is already display-ready
+)
+
+var TransferFunctions = map[string]TransferFunction{"srgb": EncodeSRGB, "gamma2": EncodeGamma2, "linear": EncodeLinear}
+
+func ParseTransferFunction(name string) (TransferFunction, error) {
+	tf, ok := TransferFunctions[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown transfer function %q (want srgb, gamma2 or linear)", name)
+	}
+	return tf, nil
+}
+
+// DisplayTransform turns linear radiance into 8-bit display values: exposure,
+// then a tone curve, then the transfer function. The zero value clamps and
+// encodes as sRGB.
+type DisplayTransform struct {
+	Exposure   float64 // in stops; each one doubles the brightness
+	Operator   ToneOperator
+	WhitePoint float64 // radiance mapped to white by ToneReinhardExtended
+	Encoding   TransferFunction
+}
+
+var DefaultDisplay = DisplayTransform{Operator: ToneClamp, WhitePoint: 4, Encoding: EncodeSRGB}
+
+// LegacyDisplay is the clamp and square root used before tone mapping existed.
+var LegacyDisplay = DisplayTransform{Operator: ToneClamp, WhitePoint: 4, Encoding: EncodeGamma2}
+
+func (d DisplayTransform) RGB(c Vec3) (r, g, b uint8) {
+	scale := math.Exp2(d.Exposure)
+	intensity := NewInterval(0.0, 0.999)
+	quantize := func(x float64) uint8 {
+		v := d.Encode(d.ToneMap(x * scale))
+		if math.IsNaN(v) {
+			v = 0
+		}
+		return uint8(256 * intensity.Clamp(v))
+	}
+	return quantize(c.X), quantize(c.Y), quantize(c.Z)
+}
+
+// ToneMap applies the operator to one linear channel.
+func (d DisplayTransform) ToneMap(x float64) float64 {
+	x = max(x, 0)
+	switch d.Operator {
+	case ToneReinhard:
+		return x / (1 + x)
+	case ToneReinhardExtended:
+		w := max(d.WhitePoint, 1e-6)
+		return min(x*(1+x/(w*w))/(1+x), 1)
+	case ToneACES:
+		return min(x*(2.51*x+0.03)/(x*(2.43*x+0.59)+0.14), 1)
+	case ToneHable:
+		const exposureBias, white = 2.0, 11.2
+		return hableCurve(x*exposureBias) / hableCurve(white)
+	}
+	return min(x, 1)
+}
+
+// hableCurve is the filmic curve from Uncharted 2, with its published
+// shoulder, linear, toe and angle strengths.
+func hableCurve(x float64) float64 {
+	const a, b, c, d, e, f = 0.15, 0.50, 0.10, 0.20, 0.02, 0.30
+	return (x*(a*x+c*b)+d*e)/(x*(a*x+b)+d*f) - e/f
+}
+
+func (d DisplayTransform) Encode(x float64) float64 {
+	switch d.Encoding {
+	case EncodeGamma2:
+		return LinearToGamma(x)
+	case EncodeLinear:
+		return x
+	}
+	return LinearToSRGB(x)
+}
+
+func LinearToSRGB(x float64) float64 {
+	if x <= 0.0031308 {
+		return 12.92 * max(x, 0)
+	}
+	return 1.055*math.Pow(x, 1/2.4) - 0.055
+}
@@ -1,82 +1,117 @@
 package main
 
-import "math"
+import (
+	"errors"
+	"math"
+	"math/rand/v2"
+)
 
-type Translate struct {
-	Offset    Vec3
-	Object    *Hittable
-	BBOXField *AABB
+// Transform places an object with an affine matrix. Rays are moved into object
+// space with the inverse; hit points and normals (by the inverse transpose) are
+// moved back to world space.
+type Transform struct {
+	Object       *Hittable
+	Matrix       Mat4
+	Inverse      Mat4
+	NormalMatrix Mat4
+	BBOXField    *AABB
 }
 
-func NewTranslateY(object *Hittable, offset Vec3) *Hittable {
-	(*object).BBOX().ShiftAABB(offset)
-	t := Hittable(&Translate{Offset: offset, Object: object, BBOXField: (*object).BBOX()})
-	return &t
+var ErrNotInvertible = errors.New("transform matrix is not invertible")
+
+// NewTransform wraps object in m, which must be invertible. Wrapping a
+// Transform again folds both matrices into one instead of nesting.
+func NewTransform(object *Hittable, m Mat4) (*Transform, error) {
+	if inner, ok := (*object).(*Transform); ok {
+		object, m = inner.Object, m.Mul(inner.Matrix)
+	}
+	inv, ok := m.Inverse()
+	if !ok {
+		return nil, ErrNotInvertible
+	}
+	return &Transform{
+		Object:       object,
+		Matrix:       m,
+		Inverse:      inv,
+		NormalMatrix: inv.Transpose(),
+		BBOXField:    TransformAABB(&m, (*object).BBOX()),
+	}, nil
 }
 
-func (t *Translate) Hit(r Ray, i *Interval, rec *HitRecord) bool {
-	offsetRay := NewRay(r.Origin.Sub(t.Offset), r.Direction, r.Time)
-	if !(*t.Object).Hit(offsetRay, i, rec) {
-		return false
+// transformed is NewTransform as a Hittable.
+func transformed(object *Hittable, m Mat4) (*Hittable, error) {
+	t, err := NewTransform(object, m)
+	if err != nil {
+		return nil, err
 	}
-	rec.P.PlusEq(t.Offset)
-	return true
+	h := Hittable(t)
+	return &h, nil
 }
 
-func (t *Translate) BBOX() *AABB {
-	return t.BBOXField
+// NewTranslate fails only for an infinite or NaN offset.
+func NewTranslate(object *Hittable, offset Vec3) (*Hittable, error) {
+	return transformed(object, TranslationMat4(offset))
 }
 
-type RotateY struct {
-	Angle     float64
-	Object    *Hittable
-	BBOXField *AABB
+// NewRotate fails if the axis is zero or the angle isn't finite.
+func NewRotate(object *Hittable, axis Vec3, angle float64) (*Hittable, error) {
+	m, err := RotationMat4(axis, angle)
+	if err != nil {
+		return nil, err
+	}
+	return transformed(object, m)
+}
+func NewRotateX(object *Hittable, angle float64) (*Hittable, error) {
+	return NewRotate(object, NewVec3(1, 0, 0), angle)
+}
+func NewRotateY(object *Hittable, angle float64) (*Hittable, error) {
+	return NewRotate(object, NewVec3(0, 1, 0), angle)
+}
+func NewRotateZ(object *Hittable, angle float64) (*Hittable, error) {
+	return NewRotate(object, NewVec3(0, 0, 1), angle)
 }
 
-func NewRotateY(object *Hittable, angle float64) *Hittable {
-	sinTheta := math.Sin(DegreesToRadians(angle))
-	cosTheta := math.Cos(DegreesToRadians(angle))
-	bbox := (*object).BBOX()
+// NewScale fails if any factor is zero.
+func NewScale(object *Hittable, factors Vec3) (*Hittable, error) {
+	return transformed(object, ScaleMat4(factors))
+}
 
-	minPoint, maxPoint := NewVec3(math.Inf(-1), math.Inf(-1), math.Inf(-1)), NewVec3(math.Inf(1), math.Inf(1), math.Inf(1))
+func (t *Transform) Hit(r Ray, i *Interval, rec *HitRecord, rng *rand.Rand) bool {
+	// the direction isn't renormalized so t values match in both spaces
+	objectRay := NewRay(t.Inverse.Point(r.Origin), t.Inverse.Vector(r.Direction), r.Time)
+	if !(*t.Object).Hit(objectRay, i, rec, rng) {
+		return false
+	}
+	rec.P = t.Matrix.Point(rec.P)
+	rec.Normal = t.NormalMatrix.Vector(rec.Normal).GetUnitVec()
+	return true
+}
 
+func (t *Transform) BBOX() *AABB {
+	return t.BBOXField
+}
+
+// TransformAABB bounds the eight transformed corners of box.
+func TransformAABB(m *Mat4, box *AABB) *AABB {
+	if box.X.Size() < 0 || box.Y.Size() < 0 || box.Z.Size() < 0 {
+		return NewEmptyAABB()
+	}
+	minPoint := NewVec3(math.Inf(1), math.Inf(1), math.Inf(1))
+	maxPoint := NewVec3(math.Inf(-1), math.Inf(-1), math.Inf(-1))
 	for i := range 2 {
 		for j := range 2 {
 			for k := range 2 {
-				x := float64(i)*bbox.X.Max + float64(1-i)*bbox.X.Min
-				y := float64(j)*bbox.Y.Max + float64(1-j)*bbox.Y.Min
-				z := float64(k)*bbox.Z.Max + float64(1-k)*bbox.Z.Min
-
-				newX := cosTheta*x + sinTheta*z
-				newZ := -sinTheta*x + cosTheta*z
-				tester := NewVec3(newX, y, newZ)
+				x := float64(i)*box.X.Max + float64(1-i)*box.X.Min
+				y := float64(j)*box.Y.Max + float64(1-j)*box.Y.Min
+				z := float64(k)*box.Z.Max + float64(1-k)*box.Z.Min
+				corner := m.Point(NewVec3(x, y, z))
 
 				for c := 0; c < 3; c++ {
-					minPoint.SetDim(c, min(minPoint.GetDim(c), tester.GetDim(c)))
-					maxPoint.SetDim(c, max(maxPoint.GetDim(c), maxPoint.GetDim(c)))
+					minPoint.SetDim(c, min(minPoint.GetDim(c), corner.GetDim(c)))
+					maxPoint.SetDim(c, max(maxPoint.GetDim(c), corner.GetDim(c)))
 				}
 			}
 		}
 	}
-	r := Hittable(&RotateY{Object: object, Angle: angle, BBOXField: NewAABBFromPoints(minPoint, maxPoint)})
-	return &r
-}
-
-func (ro *RotateY) Hit(r Ray, i *Interval, rec *HitRecord) bool {
-	sinTheta, cosTheta := math.Sin(DegreesToRadians(ro.Angle)), math.Cos(DegreesToRadians(ro.Angle))
-	origin := NewVec3(cosTheta*r.Origin.X-(sinTheta*r.Origin.Z), r.Origin.Y, sinTheta*r.Origin.X+cosTheta*r.Origin.Z)
-	direction := NewVec3(cosTheta*r.Direction.X-(sinTheta*r.Direction.Z), r.Direction.Y, sinTheta*r.Direction.X+cosTheta*r.Direction.Z)
-
-	rotatedR := NewRay(origin, direction, r.Time)
-	if !(*ro.Object).Hit(rotatedR, i, rec) {
-		return false
-	}
-
-	rec.P = NewVec3(cosTheta*rec.P.X+sinTheta*rec.P.Z, rec.P.Y, -sinTheta*rec.P.X+cosTheta*rec.P.Z)
-	rec.Normal = NewVec3(cosTheta*rec.Normal.X+sinTheta*rec.Normal.Z, rec.Normal.Y, -sinTheta*rec.Normal.X+cosTheta*rec.Normal.Z)
-	return true
-}
-
-func (ro *RotateY) BBOX() *AABB {
-	return ro.BBOXField
+	return NewAABB(NewInterval(minPoint.X, maxPoint.X), NewInterval(minPoint.Y, maxPoint.Y), NewInterval(minPoint.Z, maxPoint.Z))
 }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestTransformErrors(t *testing.T) {
+	sphere := NewSphere(NewVec3(0, 0, 0), 1, NewLambertian(NewVec3(0.5, 0.5, 0.5)))
+	for _, test := range []struct {
+		name string
+		make func() (*Hittable, error)
+		want error
+	}{
+		{"translate", func() (*Hittable, error) { return NewTranslate(sphere, NewVec3(1, 2, 3)) }, nil},
+		{"rotate", func() (*Hittable, error) { return NewRotate(sphere, NewVec3(1, 1, 0), 30) }, nil},
+		{"scale", func() (*Hittable, error) { return NewScale(sphere, NewVec3(1, -2, 3)) }, nil},
+		{"zero axis", func() (*Hittable, error) { return NewRotate(sphere, NewVec3(0, 0, 0), 30) }, ErrZeroAxis},
+		{"infinite angle", func() (*Hittable, error) { return NewRotateY(sphere, math.Inf(1)) }, ErrNotInvertible},
+		{"NaN offset", func() (*Hittable, error) { return NewTranslate(sphere, NewVec3(0, math.NaN(), 0)) }, ErrNotInvertible},
+		{"zero scale", func() (*Hittable, error) { return NewScale(sphere, NewVec3(1, 0, 1)) }, ErrNotInvertible},
+	} {
+		h, err := test.make()
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.want)
+		}
+		if err == nil && h == nil {
+			t.Errorf("%s: no object and no error", test.name)
+		}
+	}
+}
+
+func TestInverse(t *testing.T) {
+	rotation, err := RotationMat4(NewVec3(1, 2, 3), 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := TranslationMat4(NewVec3(1, -2, 5)).Mul(rotation).Mul(ScaleMat4(NewVec3(2, 3, 0.5)))
+	inv, ok := m.Inverse()
+	if !ok {
+		t.Fatal("an invertible matrix wasn't inverted")
+	}
+	product, identity := m.Mul(inv), IdentityMat4()
+	for i := range 4 {
+		for j := range 4 {
+			if math.Abs(product[i][j]-identity[i][j]) > 1e-12 {
+				t.Fatalf("m times its inverse is %v", product)
+			}
+		}
+	}
+
+	singular := ScaleMat4(NewVec3(1, 0, 1))
+	nan := IdentityMat4()
+	nan[0][1] = math.NaN()
+	infinite := TranslationMat4(NewVec3(math.Inf(-1), 0, 0))
+	for _, m := range []Mat4{singular, nan, infinite} {
+		if _, ok := m.Inverse(); ok {
+			t.Errorf("inverted %v", m)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+)
+
+type Triangle struct {
+	V0, V1, V2 Vec3
+	Normal     Vec3
+	Mat        *Material
+	BBOXField  *AABB
+}
+
+func NewTriangle(a, b, c Vec3, m *Material) *Hittable {
+	e1, e2 := b.Sub(a), c.Sub(a)
+	tri := Triangle{V0: a, V1: b, V2: c, Normal: Cross(&e1, &e2).GetUnitVec(), Mat: m, BBOXField: triangleBBOX(a, b, c)}
+	h := Hittable(&tri)
+	return &h
+}
+
+func (t *Triangle) Hit(r Ray, i *Interval, rec *HitRecord, rng *rand.Rand) bool {
+	tHit, b1, b2, ok := IntersectTriangle(r, t.V0, t.V1, t.V2, i)
+	if !ok {
+		return false
+	}
+	rec.T = tHit
+	rec.P = r.at(tHit)
+	rec.U, rec.V = b1, b2
+	rec.MaterialPointer = t.Mat
+	rec.Object = t
+	rec.SetFaceNormal(r, t.Normal)
+	return true
+}
+
+func (t *Triangle) BBOX() *AABB {
+	return t.BBOXField
+}
+
+// IntersectTriangle is the Möller–Trumbore test; b1 and b2 are the barycentric
+// weights of the second and third vertices.
+func IntersectTriangle(r Ray, v0, v1, v2 Vec3, i *Interval) (t, b1, b2 float64, ok bool) {
+	e1, e2 := v1.Sub(v0), v2.Sub(v0)
+	pvec := Cross(&r.Direction, &e2)
+	det := Dot(&e1, &pvec)
+	if math.Abs(det) < 1e-12 {
+		return 0, 0, 0, false
+	}
+	invDet := 1 / det
+
+	tvec := r.Origin.Sub(v0)
+	b1 = Dot(&tvec, &pvec) * invDet
+	if b1 < 0 || b1 > 1 {
+		return 0, 0, 0, false
+	}
+	qvec := Cross(&tvec, &e1)
+	b2 = Dot(&r.Direction, &qvec) * invDet
+	if b2 < 0 || b1+b2 > 1 {
+		return 0, 0, 0, false
+	}
+	t = Dot(&e2, &qvec) * invDet
+	if !i.Surrounds(t) {
+		return 0, 0, 0, false
+	}
+	return t, b1, b2, true
+}
+
+func triangleBBOX(a, b, c Vec3) *AABB {
+	return NewAABB(
+		NewInterval(min(a.X, b.X, c.X), max(a.X, b.X, c.X)),
+		NewInterval(min(a.Y, b.Y, c.Y), max(a.Y, b.Y, c.Y)),
+		NewInterval(min(a.Z, b.Z, c.Z), max(a.Z, b.Z, c.Z)),
+	)
+}
+
+// MeshFace indexes one triangle's corners into the mesh buffers. Normal and UV
+// indices are -1 when the corner has none.
+type MeshFace struct {
+	V, VT, VN [3]int
+}
+
+type TriangleMesh struct {
+	Vertices []Vec3
+	Normals  []Vec3
+	UVs      [][2]float64
+	Faces    []MeshFace
+	Mat      *Material
+}
+
+func NewTriangleMesh(vertices, normals []Vec3, uvs [][2]float64, faces []MeshFace, m *Material) *TriangleMesh {
+	return &TriangleMesh{Vertices: vertices, Normals: normals, UVs: uvs, Faces: faces, Mat: m}
+}
+
+// ComputeVertexNormals replaces the normal buffer with area-weighted vertex
+// normals so meshes without vn data still shade smoothly.
+func (mesh *TriangleMesh) ComputeVertexNormals() {
+	normals := make([]Vec3, len(mesh.Vertices))
+	for f := range mesh.Faces {
+		face := &mesh.Faces[f]
+		a, b, c := mesh.Vertices[face.V[0]], mesh.Vertices[face.V[1]], mesh.Vertices[face.V[2]]
+		e1, e2 := b.Sub(a), c.Sub(a)
+		n := Cross(&e1, &e2) // length is twice the area
+		for k := range 3 {
+			normals[face.V[k]].PlusEq(n)
+			face.VN[k] = face.V[k]
+		}
+	}
+	for k := range normals {
+		if !normals[k].NearZero() {
+			normals[k].MakeUnitVec()
+		}
+	}
+	mesh.Normals = normals
+}
+
+func (mesh *TriangleMesh) Triangles() []*Hittable {
+	tris := make([]*Hittable, len(mesh.Faces))
+	for f := range mesh.Faces {
+		tri := &MeshTriangle{Mesh: mesh, Face: f}
+		face := &mesh.Faces[f]
+		tri.BBOXField = triangleBBOX(mesh.Vertices[face.V[0]], mesh.Vertices[face.V[1]], mesh.Vertices[face.V[2]])
+		h := Hittable(tri)
+		tris[f] = &h
+	}
+	return tris
+}
+
+func (mesh *TriangleMesh) NewBVH(opts BVHOptions) *Hittable {
+	tris := mesh.Triangles()
+	return NewBVH(tris, opts)
+}
+
+type MeshTriangle struct {
+	Mesh      *TriangleMesh
+	Face      int
+	BBOXField *AABB
+}
+
+func (t *MeshTriangle) Hit(r Ray, i *Interval, rec *HitRecord, rng *rand.Rand) bool {
+	mesh := t.Mesh
+	face := &mesh.Faces[t.Face]
+	v0, v1, v2 := mesh.Vertices[face.V[0]], mesh.Vertices[face.V[1]], mesh.Vertices[face.V[2]]
+	tHit, b1, b2, ok := IntersectTriangle(r, v0, v1, v2, i)
+	if !ok {
+		return false
+	}
+	b0 := 1 - b1 - b2
+
+	rec.T = tHit
+	rec.P = r.at(tHit)
+	rec.MaterialPointer = mesh.Mat
+	rec.Object = mesh
+
+	if face.VT[0] >= 0 && face.VT[1] >= 0 && face.VT[2] >= 0 {
+		uv0, uv1, uv2 := mesh.UVs[face.VT[0]], mesh.UVs[face.VT[1]], mesh.UVs[face.VT[2]]
+		rec.U = b0*uv0[0] + b1*uv1[0] + b2*uv2[0]
+		rec.V = b0*uv0[1] + b1*uv1[1] + b2*uv2[1]
+	} else {
+		rec.U, rec.V = b1, b2
+	}
+
+	e1, e2 := v1.Sub(v0), v2.Sub(v0)
+	geometricNormal := Cross(&e1, &e2).GetUnitVec()
+	rec.SetFaceNormal(r, geometricNormal)
+
+	if face.VN[0] >= 0 && face.VN[1] >= 0 && face.VN[2] >= 0 {
+		shading := mesh.Normals[face.VN[0]].Scale(b0).Add(mesh.Normals[face.VN[1]].Scale(b1)).Add(mesh.Normals[face.VN[2]].Scale(b2))
+		if !shading.NearZero() {
+			shading.MakeUnitVec()
+			// keep the interpolated normal on the same side as the geometric one
+			if Dot(&shading, &rec.Normal) < 0 {
+				shading = shading.Negate()
+			}
+			rec.Normal = shading
+		}
+	}
+	return true
+}
+
+func (t *MeshTriangle) BBOX() *AABB {
+	return t.BBOXField
+}
@@ -1,43 +1,21 @@
 package main
 
 import (
-	"fmt"
-	"log"
 	"math"
 	"math/rand/v2"
-	"os"
 )
 
-func InitImage(w, h int) {
-	f, err := os.Create("../out.ppm")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer f.Close()
-	fmt.Fprintf(f, "P3\n%d %d\n255\n", w, h)
-}
-func WriteColor(color Vec3) {
-
-	intensity := NewInterval(0.0, 0.999)
-
-	r := int(256 * intensity.Clamp(LinearToGamma(color.X)))
-	g := int(256 * intensity.Clamp(LinearToGamma(color.Y)))
-	b := int(256 * intensity.Clamp(LinearToGamma(color.Z)))
-
-	file, err := os.OpenFile("../out.ppm", os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-
-	fmt.Fprintf(file, "%d %d %d\n", r, g, b)
-}
 func LinearToGamma(linearComponent float64) float64 {
 	if linearComponent > 0 {
 		return math.Sqrt(linearComponent)
 	}
 	return 0
 }
+
+// Luminance weights linear Rec. 709 primaries by their perceived brightness.
+func Luminance(c Vec3) float64 {
+	return 0.2126*c.X + 0.7152*c.Y + 0.0722*c.Z
+}
 func DegreesToRadians(degrees float64) float64 {
 	return degrees * math.Pi / 180
 }
@@ -61,6 +39,6 @@ func SrgbToLinear(c byte) float64 {
 	return (math.Pow((float64((v + 0.055) / (1.055))), 2.4))
 }
 
-func RandFloatInRange(min, max float64) float64 {
-	return min + rand.Float64()*(max-min)
+func RandFloatInRange(rng *rand.Rand, min, max float64) float64 {
+	return min + rng.Float64()*(max-min)
 }
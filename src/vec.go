@@ -82,8 +82,8 @@ func (v *Vec3) LengthSquared() float64 {
 func (v Vec3) Length() float64 {
 	return math.Sqrt(v.LengthSquared())
 }
-func (Normal *Vec3) RandomOnHemisphere() Vec3 {
-	OnUnitSphere := RandomUnitVector()
+func (Normal *Vec3) RandomOnHemisphere(rng *rand.Rand) Vec3 {
+	OnUnitSphere := RandomUnitVector(rng)
 	if Dot(&OnUnitSphere, Normal) < 0.0 {
 		OnUnitSphere.ScaleAssign(-1.0)
 	}
@@ -127,28 +127,28 @@ func Refract(uv, n *Vec3, etaiOverEtat float64) Vec3 {
 	return rOutParallel.Add(rOutPerp)
 }
 
-func RandomUnitVector() Vec3 {
+func RandomUnitVector(rng *rand.Rand) Vec3 {
 	for {
-		p := NewBoundedRandomVec(-1, 1)
+		p := NewBoundedRandomVec(rng, -1, 1)
 		lensq := p.LengthSquared()
 		if 1e-160 < lensq && lensq <= 1 {
 			return p.Scale(1.0 / math.Sqrt(lensq))
 		}
 	}
 }
-func RandomInUnitDisk() Vec3 {
+func RandomInUnitDisk(rng *rand.Rand) Vec3 {
 	for {
-		p := NewBoundedRandomVec(-1, 1)
+		p := NewBoundedRandomVec(rng, -1, 1)
 		if p.LengthSquared() < 1 {
 			return p
 		}
 	}
 }
-func NewRandomVec() Vec3 {
-	return NewVec3(rand.Float64(), rand.Float64(), rand.Float64())
+func NewRandomVec(rng *rand.Rand) Vec3 {
+	return NewVec3(rng.Float64(), rng.Float64(), rng.Float64())
 }
-func NewBoundedRandomVec(min, max float64) Vec3 {
-	return NewVec3(RandFloatInRange(min, max), RandFloatInRange(min, max), RandFloatInRange(min, max))
+func NewBoundedRandomVec(rng *rand.Rand, min, max float64) Vec3 {
+	return NewVec3(RandFloatInRange(rng, min, max), RandFloatInRange(rng, min, max), RandFloatInRange(rng, min, max))
 }
 func (v Vec3) PrintVec() {
 	fmt.Printf("<%f,%f,%f>", v.X, v.Y, v.Z)